@@ -0,0 +1,348 @@
+// Package bench generates load against a WatsonTcp server and measures
+// throughput and latency. It drives a number of clients that each send
+// messages back to back for a fixed duration, mixing plain sends with sync
+// requests, and reports message rates, latency percentiles and error counts.
+//
+// With an empty Config.Addr the server runs in-process on a loopback port,
+// so two builds of the library can be compared on one machine without any
+// other setup.
+package bench
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/client"
+	"github.com/WasimAhmad/watsontcp-go/message"
+	"github.com/WasimAhmad/watsontcp-go/server"
+)
+
+const modulePath = "github.com/WasimAhmad/watsontcp-go"
+
+// Config describes a benchmark run. Start from DefaultConfig, which fills
+// in the client and server option defaults.
+type Config struct {
+	// Addr is the server to load. Empty starts an in-process server on a
+	// loopback port that echoes sync requests. A remote server must answer
+	// sync requests for SyncRatio above zero, as 'watsontcp serve' does.
+	Addr string
+
+	// TLSConfig is used by the clients.
+	TLSConfig *tls.Config
+
+	// Options apply to every client.
+	Options client.Options
+
+	// ServerTLSConfig and ServerOptions configure the in-process server.
+	// Its preshared key defaults to Options.PresharedKey.
+	ServerTLSConfig *tls.Config
+	ServerOptions   server.Options
+
+	// Clients is the number of connections, each sending from its own
+	// goroutine. Zero uses one.
+	Clients int
+
+	// Size is the payload size of every message in bytes.
+	Size int
+
+	// SyncRatio is the fraction of messages sent as sync requests, from 0
+	// for only plain sends to 1 for only sync requests.
+	SyncRatio float64
+
+	// Duration is how long the clients send for. Zero uses ten seconds.
+	Duration time.Duration
+
+	// Timeout bounds each sync request. Zero uses five seconds.
+	Timeout time.Duration
+}
+
+// DefaultConfig returns a configuration for four clients sending 1 KiB
+// messages for ten seconds against an in-process server.
+func DefaultConfig() Config {
+	return Config{
+		Options:       client.DefaultOptions(),
+		ServerOptions: server.DefaultOptions(),
+		Clients:       4,
+		Size:          1024,
+		Duration:      10 * time.Second,
+		Timeout:       5 * time.Second,
+	}
+}
+
+// Errors counts the failures of a run.
+type Errors struct {
+	// Connect counts clients that could not connect. They take no part in
+	// the run.
+	Connect int64 `json:"connect"`
+
+	// Send counts sends and sync requests that returned an error.
+	Send int64 `json:"send"`
+
+	// Timeout counts sync requests without a response within
+	// Config.Timeout.
+	Timeout int64 `json:"timeout"`
+
+	// Disconnect counts clients whose connection was lost during the run.
+	Disconnect int64 `json:"disconnect"`
+}
+
+// Total returns the number of errors of every kind.
+func (e Errors) Total() int64 {
+	return e.Connect + e.Send + e.Timeout + e.Disconnect
+}
+
+// Result is the outcome of a run. Durations are encoded in JSON as
+// nanoseconds.
+type Result struct {
+	// Version is the version of this module in the running binary, or
+	// "(devel)" for a build from a working tree.
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+
+	Addr      string  `json:"addr"`
+	InProcess bool    `json:"in_process"`
+	Clients   int     `json:"clients"`
+	Size      int     `json:"size"`
+	SyncRatio float64 `json:"sync_ratio"`
+
+	// Elapsed is the time from the first send to the end of the last one.
+	// It exceeds Config.Duration when the server falls behind and the last
+	// sends wait for it to drain the connections.
+	Elapsed time.Duration `json:"elapsed_ns"`
+
+	// Messages and Bytes count successful sends and their payload bytes.
+	Messages          int64   `json:"messages"`
+	Bytes             int64   `json:"bytes"`
+	MessagesPerSecond float64 `json:"messages_per_second"`
+	BytesPerSecond    float64 `json:"bytes_per_second"`
+
+	Errors Errors `json:"errors"`
+
+	// Latency covers every successful message. For a plain send it is the
+	// time to write the message; for a sync request it is the round trip
+	// to the response.
+	Latency Latency `json:"latency"`
+	Sync    Latency `json:"sync"`
+	Async   Latency `json:"async"`
+}
+
+// Run connects the clients, sends for cfg.Duration or until ctx is done and
+// returns the measurements. It fails only if the in-process server cannot
+// start or no client can connect.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	if cfg.Clients <= 0 {
+		cfg.Clients = 1
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = 10 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	cfg.Size = max(cfg.Size, 0)
+	cfg.SyncRatio = min(max(cfg.SyncRatio, 0), 1)
+
+	res := &Result{
+		Version:   version(),
+		GoVersion: runtime.Version(),
+		Addr:      cfg.Addr,
+		InProcess: cfg.Addr == "",
+		Clients:   cfg.Clients,
+		Size:      cfg.Size,
+		SyncRatio: cfg.SyncRatio,
+	}
+	if res.InProcess {
+		srv, addr, err := startServer(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("bench: start server: %w", err)
+		}
+		defer srv.Stop()
+		res.Addr = addr
+	}
+
+	workers, err := connect(res.Addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	res.Errors.Connect = int64(cfg.Clients - len(workers))
+	defer func() {
+		for _, w := range workers {
+			w.cli.Disconnect()
+		}
+	}()
+
+	payload := make([]byte, cfg.Size)
+	for i := range payload {
+		payload[i] = byte('a' + i%26)
+	}
+	runCtx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(runCtx, cfg, payload)
+		}()
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+
+	var all, syncs, asyncs []time.Duration
+	for _, w := range workers {
+		syncs = append(syncs, w.sync...)
+		asyncs = append(asyncs, w.async...)
+		res.Errors.Send += w.errors.Send
+		res.Errors.Timeout += w.errors.Timeout
+		res.Errors.Disconnect += w.errors.Disconnect
+	}
+	all = append(append(all, syncs...), asyncs...)
+	res.Messages = int64(len(all))
+	res.Bytes = res.Messages * int64(cfg.Size)
+	if secs := res.Elapsed.Seconds(); secs > 0 {
+		res.MessagesPerSecond = float64(res.Messages) / secs
+		res.BytesPerSecond = float64(res.Bytes) / secs
+	}
+	res.Latency = summarize(all)
+	res.Sync = summarize(syncs)
+	res.Async = summarize(asyncs)
+	return res, nil
+}
+
+// startServer starts the in-process server and returns its address.
+func startServer(cfg Config) (*server.Server, string, error) {
+	opts := cfg.ServerOptions
+	if opts.PresharedKey == "" {
+		opts.PresharedKey = cfg.Options.PresharedKey
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	var srv *server.Server
+	srv = server.New("", cfg.ServerTLSConfig, server.Callbacks{
+		OnMessage: func(id string, msg *message.Message, data []byte) {
+			if msg.SyncRequest {
+				srv.Send(id, &message.Message{SyncResponse: true, ConversationGUID: msg.ConversationGUID}, data)
+			}
+		},
+	}, &opts)
+	if err := srv.Serve(ln); err != nil {
+		ln.Close()
+		return nil, "", err
+	}
+	return srv, ln.Addr().String(), nil
+}
+
+// worker is one client and the measurements it collects.
+type worker struct {
+	cli    *client.Client
+	lost   chan struct{}
+	sync   []time.Duration
+	async  []time.Duration
+	errors Errors
+}
+
+// connect opens the clients concurrently, failing only if none connects.
+func connect(addr string, cfg Config) ([]*worker, error) {
+	var (
+		mu      sync.Mutex
+		workers []*worker
+		errs    []error
+		wg      sync.WaitGroup
+	)
+	for range cfg.Clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := &worker{lost: make(chan struct{})}
+			cb := client.Callbacks{
+				OnDisconnect: func(message.DisconnectReason) { close(w.lost) },
+			}
+			opts := cfg.Options
+			w.cli = client.New(addr, cfg.TLSConfig, cb, &opts)
+			err := w.cli.Connect()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			workers = append(workers, w)
+		}()
+	}
+	wg.Wait()
+	if len(workers) == 0 {
+		return nil, fmt.Errorf("bench: no client connected: %w", errors.Join(errs...))
+	}
+	return workers, nil
+}
+
+// run sends until ctx is done or the connection is lost.
+func (w *worker) run(ctx context.Context, cfg Config, payload []byte) {
+	for ctx.Err() == nil {
+		isSync := cfg.SyncRatio > 0 && rand.Float64() < cfg.SyncRatio
+		start := time.Now()
+		var err error
+		if isSync {
+			reqCtx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+			_, _, err = w.cli.SendSync(reqCtx, &message.Message{}, payload)
+			cancel()
+		} else {
+			err = w.cli.Send(&message.Message{}, payload)
+		}
+		elapsed := time.Since(start)
+		switch {
+		case err == nil && isSync:
+			w.sync = append(w.sync, elapsed)
+		case err == nil:
+			w.async = append(w.async, elapsed)
+		case w.disconnected():
+			w.errors.Disconnect++
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			w.errors.Timeout++
+		default:
+			w.errors.Send++
+		}
+	}
+}
+
+func (w *worker) disconnected() bool {
+	select {
+	case <-w.lost:
+		return true
+	default:
+		return false
+	}
+}
+
+// version returns the version of this module linked into the binary.
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != modulePath {
+			continue
+		}
+		if dep.Replace != nil {
+			return dep.Replace.Version
+		}
+		return dep.Version
+	}
+	return ""
+}
@@ -0,0 +1,112 @@
+package bench_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/bench"
+	"github.com/WasimAhmad/watsontcp-go/message"
+	"github.com/WasimAhmad/watsontcp-go/server"
+)
+
+func checkLatency(t *testing.T, name string, l bench.Latency) {
+	t.Helper()
+	if l.Count == 0 {
+		t.Fatalf("%s: no samples", name)
+	}
+	if !(l.Min <= l.P50 && l.P50 <= l.P90 && l.P90 <= l.P99 && l.P99 <= l.Max) || l.Mean < l.Min || l.Mean > l.Max {
+		t.Fatalf("%s: inconsistent summary %+v", name, l)
+	}
+}
+
+func TestRunInProcess(t *testing.T) {
+	cfg := bench.DefaultConfig()
+	cfg.Clients = 3
+	cfg.Size = 128
+	cfg.SyncRatio = 0.5
+	cfg.Duration = 300 * time.Millisecond
+	cfg.Options.PresharedKey = "0123456789abcdef"
+	res, err := bench.Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !res.InProcess || res.Clients != 3 || res.Errors.Total() != 0 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	checkLatency(t, "sync", res.Sync)
+	checkLatency(t, "async", res.Async)
+	if res.Messages != res.Sync.Count+res.Async.Count || res.Latency.Count != res.Messages {
+		t.Fatalf("counts do not add up: %d messages, %d sync, %d async", res.Messages, res.Sync.Count, res.Async.Count)
+	}
+	if res.Bytes != res.Messages*128 || res.MessagesPerSecond <= 0 {
+		t.Fatalf("throughput: %d bytes, %.1f msg/s", res.Bytes, res.MessagesPerSecond)
+	}
+
+	var text bytes.Buffer
+	if err := res.WriteText(&text); err != nil {
+		t.Fatalf("text: %v", err)
+	}
+	for _, want := range []string{"in-process", "3 clients", "50% sync", "p99", "sync", "async"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text report lacks %q:\n%s", want, text.String())
+		}
+	}
+	var js bytes.Buffer
+	if err := res.WriteJSON(&js); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	var decoded bench.Result
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if decoded.Messages != res.Messages || decoded.Sync.P99 != res.Sync.P99 {
+		t.Fatalf("json round trip: %+v", decoded)
+	}
+}
+
+func TestRunRemote(t *testing.T) {
+	var srv *server.Server
+	srv = server.New("127.0.0.1:30340", nil, server.Callbacks{
+		OnMessage: func(id string, msg *message.Message, data []byte) {
+			// answer late so that every sync request times out
+			if msg.SyncRequest {
+				time.Sleep(100 * time.Millisecond)
+				srv.Send(id, &message.Message{SyncResponse: true, ConversationGUID: msg.ConversationGUID}, data)
+			}
+		},
+	}, nil)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cfg := bench.DefaultConfig()
+	cfg.Addr = "127.0.0.1:30340"
+	cfg.Clients = 2
+	cfg.SyncRatio = 1
+	cfg.Duration = 200 * time.Millisecond
+	cfg.Timeout = 20 * time.Millisecond
+	res, err := bench.Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if res.InProcess || res.Addr != cfg.Addr {
+		t.Fatalf("expected a remote run: %+v", res)
+	}
+	if res.Errors.Timeout == 0 || res.Messages != 0 {
+		t.Fatalf("expected only timeouts: %d messages, errors %+v", res.Messages, res.Errors)
+	}
+}
+
+func TestRunNoServer(t *testing.T) {
+	cfg := bench.DefaultConfig()
+	cfg.Addr = "127.0.0.1:30349"
+	cfg.Duration = 100 * time.Millisecond
+	if _, err := bench.Run(context.Background(), cfg); err == nil {
+		t.Fatal("expected an error without a server")
+	}
+}
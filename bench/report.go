@@ -0,0 +1,113 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// Latency summarizes the latencies of a set of messages. Percentiles use
+// the nearest-rank method.
+type Latency struct {
+	Count int64         `json:"count"`
+	Min   time.Duration `json:"min_ns"`
+	Mean  time.Duration `json:"mean_ns"`
+	P50   time.Duration `json:"p50_ns"`
+	P90   time.Duration `json:"p90_ns"`
+	P99   time.Duration `json:"p99_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+// summarize sorts samples in place and returns their summary.
+func summarize(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	slices.Sort(samples)
+	var total time.Duration
+	for _, d := range samples {
+		total += d
+	}
+	return Latency{
+		Count: int64(len(samples)),
+		Min:   samples[0],
+		Mean:  total / time.Duration(len(samples)),
+		P50:   percentile(samples, 0.50),
+		P90:   percentile(samples, 0.90),
+		P99:   percentile(samples, 0.99),
+		Max:   samples[len(samples)-1],
+	}
+}
+
+// percentile returns the q-th quantile of sorted.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	rank := int(math.Ceil(q * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+// WriteText writes a human-readable report of r to w.
+func (r *Result) WriteText(w io.Writer) error {
+	server := r.Addr
+	if r.InProcess {
+		server = "in-process " + r.Addr
+	}
+	version := r.Version
+	if version == "" {
+		version = "unknown"
+	}
+	fmt.Fprintf(w, "%-11s%s\n", "server", server)
+	fmt.Fprintf(w, "%-11s%s (%s)\n", "library", version, r.GoVersion)
+	fmt.Fprintf(w, "%-11s%d clients, %d byte messages, %.0f%% sync, %s\n", "load",
+		r.Clients, r.Size, r.SyncRatio*100, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "%-11s%d messages, %.1f msg/s, %s/s\n", "throughput",
+		r.Messages, r.MessagesPerSecond, bytesString(r.BytesPerSecond))
+	fmt.Fprintf(w, "%-11s%d (connect %d, send %d, timeout %d, disconnect %d)\n\n", "errors",
+		r.Errors.Total(), r.Errors.Connect, r.Errors.Send, r.Errors.Timeout, r.Errors.Disconnect)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "latency\tcount\tmin\tmean\tp50\tp90\tp99\tmax\t")
+	for _, row := range []struct {
+		name string
+		l    Latency
+	}{{"all", r.Latency}, {"sync", r.Sync}, {"async", r.Async}} {
+		if row.l.Count == 0 {
+			continue
+		}
+		l := row.l
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t\n", row.name, l.Count,
+			round(l.Min), round(l.Mean), round(l.P50), round(l.P90), round(l.P99), round(l.Max))
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes r to w as a single JSON object.
+func (r *Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// round shortens d to three significant digits for display.
+func round(d time.Duration) time.Duration {
+	for unit := time.Duration(1); unit < time.Hour; unit *= 10 {
+		if d < 1000*unit {
+			return d.Round(unit)
+		}
+	}
+	return d.Round(time.Second)
+}
+
+func bytesString(n float64) string {
+	const unit = 1024
+	suffixes := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= unit && i < len(suffixes)-1 {
+		n /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", n, suffixes[i])
+}
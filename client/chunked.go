@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/internal/chunk"
+	"github.com/WasimAhmad/watsontcp-go/internal/filexfer"
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// SendChunked streams r to the server until EOF without knowing its length
+// in advance. The data is sent as a sequence of frames of up to
+// Options.ChunkSize bytes and the write lock is released between chunks, so
+// other messages can be sent while the stream is in progress. The server
+// receives the stream as a single reader in OnStream, or as one buffered
+// payload in OnMessage.
+//
+// Sending stops and the stream is canceled at the receiver when ctx is done
+// or r returns an error. If the receiver cancels the stream, SendChunked
+// returns an error wrapping message.ErrStreamCanceled. A blocked Read on r
+// is not interrupted.
+func (c *Client) SendChunked(ctx context.Context, msg *message.Message, r io.Reader) error {
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	if r == nil {
+		return errors.New("reader nil")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	p := &message.Payload{Stream: r, Length: -1}
+	if err := c.intercept(c.options.SendInterceptors, msg, p); err != nil {
+		return err
+	}
+	c.logf("sending chunked stream: %+v", msg)
+	return c.sendChunks(ctx, msg, p.Stream)
+}
+
+// sendChunks writes r as a chunked stream. With multiplexing enabled each
+// stream is its own logical channel.
+func (c *Client) sendChunks(ctx context.Context, msg *message.Message, r io.Reader) error {
+	id := newGUID()
+	return chunk.Send(ctx, c.chunks, id, c.options.ChunkSize, msg, r, func(m *message.Message, data []byte) error {
+		return c.writeOn(id, m, &message.Payload{Data: data})
+	})
+}
+
+// readChunk reads the payload of a chunked stream frame and hands it to the
+// demultiplexer. It returns false if the connection failed.
+func (c *Client) readChunk(msg *message.Message) bool {
+	payload := make([]byte, msg.ContentLength)
+	if _, err := io.ReadFull(c.conn, payload); err != nil {
+		c.report(err)
+		return false
+	}
+	c.stats.IncrementReceivedMessages()
+	c.stats.AddReceivedBytes(int64(len(payload)))
+	c.mu.Lock()
+	c.lastReceived = time.Now()
+	c.mu.Unlock()
+
+	f, _ := chunk.Parse(msg, payload)
+	if err := c.verify(msg, payload); err != nil {
+		c.chunks.Fail(f, err)
+		return true
+	}
+	rd := c.chunks.Handle(f)
+	if rd == nil {
+		return true
+	}
+	msg.ContentLength = f.Length
+	p := &message.Payload{Stream: rd, Length: f.Length}
+	if err := c.intercept(c.options.ReceiveInterceptors, msg, p); err != nil {
+		c.logf("rejected chunked stream: %v", err)
+		rd.Close()
+		return true
+	}
+	go c.deliverStream(msg, rd, p.Stream)
+	return true
+}
+
+// deliverStream hands a chunked stream to OnStream, or buffers it for
+// OnMessage, and cancels whatever the callback leaves unread.
+func (c *Client) deliverStream(msg *message.Message, rd *chunk.Reader, r io.Reader) {
+	defer rd.Close()
+	if c.callbacks.OnStream != nil && c.callbacks.OnMessage == nil && msg.Metadata[message.MetadataPubSub] == nil && !filexfer.IsTransfer(msg) {
+		c.callbacks.OnStream(msg, r)
+		return
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		c.logf("chunked stream failed: %v", err)
+		return
+	}
+	if c.dispatchPubSub(msg, data) {
+		return
+	}
+	if filexfer.IsTransfer(msg) {
+		c.handleFile(msg, data)
+		return
+	}
+	if c.callbacks.OnMessage != nil {
+		c.callbacks.OnMessage(msg, data)
+	}
+}
+
+func (c *Client) cancelChunk(id, reason, dest string) {
+	if err := c.write(chunk.CancelMessage(id, reason, dest), &message.Payload{}); err != nil {
+		c.logf("cancel stream %s failed: %v", id, err)
+	}
+}
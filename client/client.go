@@ -12,15 +12,24 @@ import (
 	"sync"
 	"time"
 
+	"github.com/WasimAhmad/watsontcp-go/internal/chunk"
+	"github.com/WasimAhmad/watsontcp-go/internal/filexfer"
+	"github.com/WasimAhmad/watsontcp-go/internal/mux"
+	"github.com/WasimAhmad/watsontcp-go/internal/transport"
 	"github.com/WasimAhmad/watsontcp-go/message"
 	"github.com/WasimAhmad/watsontcp-go/stats"
 )
 
 type Callbacks struct {
 	OnConnect    func()
-	OnDisconnect func()
+	OnDisconnect func(reason message.DisconnectReason)
 	OnMessage    func(msg *message.Message, data []byte)
 	OnStream     func(msg *message.Message, r io.Reader)
+
+	// OnError is called with read, parse and write failures on the
+	// connection. Errors that only mean the connection closed are not
+	// reported. Write failures are also returned to the sender.
+	OnError func(err error)
 }
 
 type Client struct {
@@ -39,6 +48,16 @@ type Client struct {
 	dcOnce       sync.Once
 	lastReceived time.Time
 	mu           sync.Mutex
+
+	subs   map[string]TopicHandler
+	subsMu sync.Mutex
+
+	chunks *chunk.Demux
+	mux    *mux.Writer
+
+	files       *filexfer.Receiver
+	fileHandler FileHandler
+	filesMu     sync.Mutex
 }
 
 func (c *Client) logf(format string, args ...any) {
@@ -63,7 +82,7 @@ func New(addr string, tlsConf *tls.Config, cb Callbacks, opts *Options) *Client
 		defaultOpts := DefaultOptions()
 		opts = &defaultOpts
 	}
-	return &Client{
+	c := &Client{
 		Addr:      addr,
 		TLSConfig: tlsConf,
 		callbacks: cb,
@@ -71,17 +90,15 @@ func New(addr string, tlsConf *tls.Config, cb Callbacks, opts *Options) *Client
 		stats:     stats.New(),
 		done:      make(chan struct{}),
 	}
+	c.chunks = chunk.NewDemux(c.cancelChunk)
+	return c
 }
 
 func (c *Client) Connect() error {
 	if c.conn != nil {
 		return errors.New("already connected")
 	}
-	d := net.Dialer{Timeout: c.options.ConnectTimeout}
-	if c.options.KeepAlive.Enable {
-		d.KeepAlive = c.options.KeepAlive.Time
-	}
-	conn, err := d.Dial("tcp", c.Addr)
+	conn, err := c.dial()
 	if err != nil {
 		return err
 	}
@@ -96,7 +113,7 @@ func (c *Client) Connect() error {
 	c.conn = conn
 	if c.options.PresharedKey != "" {
 		authMsg := &message.Message{Status: message.StatusAuthRequested, PresharedKey: []byte(c.options.PresharedKey)}
-		if err := c.Send(authMsg, nil); err != nil {
+		if err := c.write(authMsg, &message.Payload{}); err != nil {
 			c.conn.Close()
 			c.conn = nil
 			return err
@@ -118,7 +135,10 @@ func (c *Client) Connect() error {
 			if err != nil {
 				return err
 			}
-			return errors.New("authentication failed")
+			if err := rejection(resp); err != nil {
+				return err
+			}
+			return ErrAuthFailed
 		}
 	}
 
@@ -146,11 +166,17 @@ func (c *Client) Connect() error {
 		if err != nil {
 			return err
 		}
-		return errors.New("registration failed")
+		if err := rejection(regMsg); err != nil {
+			return err
+		}
+		return ErrRegistrationFailed
 	}
 	c.mu.Lock()
 	c.lastReceived = time.Now()
 	c.mu.Unlock()
+	if c.options.Multiplex {
+		c.mux = mux.New(c.conn)
+	}
 	if c.callbacks.OnConnect != nil {
 		go c.callbacks.OnConnect()
 	}
@@ -162,78 +188,229 @@ func (c *Client) Connect() error {
 }
 
 func (c *Client) Disconnect() {
+	c.disconnect(message.DisconnectNormal)
+}
+
+// disconnect closes the connection once, reporting reason to OnDisconnect.
+func (c *Client) disconnect(reason message.DisconnectReason) {
 	c.dcOnce.Do(func() {
 		close(c.done)
 		if c.conn != nil {
 			c.conn.Close()
+			c.stats.IncrementDisconnects(reason)
 		}
+		c.chunks.Close(errors.New("connection closed"))
+		if c.mux != nil {
+			c.mux.Close(errors.New("connection closed"))
+		}
+		c.failPending()
 		if c.callbacks.OnDisconnect != nil {
-			c.callbacks.OnDisconnect()
+			c.callbacks.OnDisconnect(reason)
+		}
+	})
+}
+
+// alive reports whether the connection is open and has not been lost.
+func (c *Client) alive() bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+		return c.conn != nil
+	}
+}
+
+// failPending fails every sync request still waiting for a response so that
+// SendSync and SendFile return instead of waiting for their deadline.
+func (c *Client) failPending() {
+	c.respMap.Range(func(key, val any) bool {
+		if c.respMap.CompareAndDelete(key, val) {
+			ch := val.(chan *response)
+			ch <- &response{err: ErrNotConnected}
+			close(ch)
 		}
+		return true
 	})
 }
 
 func (c *Client) Send(msg *message.Message, data []byte) error {
 	if c.conn == nil {
-		return errors.New("not connected")
+		return ErrNotConnected
 	}
-	c.logf("sending message: %+v length=%d", msg, len(data))
-	msg.ContentLength = int64(len(data))
-	msg.TimestampUtc = time.Now().UTC()
-	header, err := message.BuildHeader(msg)
-	if err != nil {
+	p := &message.Payload{Data: data}
+	if err := c.intercept(c.options.SendInterceptors, msg, p); err != nil {
 		return err
 	}
-	c.writeMu.Lock()
-	defer c.writeMu.Unlock()
-	if _, err := c.conn.Write(header); err != nil {
-		return err
+	if c.fragment(msg, p) {
+		return c.sendFragmented(msg, p)
 	}
-	if len(data) > 0 {
-		if _, err := c.conn.Write(data); err != nil {
-			return err
-		}
+	return c.write(msg, p)
+}
+
+// SendTo asks the server to relay msg with data to the client identified by
+// dest. The server must have Relay enabled.
+func (c *Client) SendTo(dest string, msg *message.Message, data []byte) error {
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]any)
 	}
-	c.stats.IncrementSentMessages()
-	c.stats.AddSentBytes(int64(len(header) + len(data)))
-	c.logf("sent %d bytes", len(header)+len(data))
-	return nil
+	msg.Metadata[message.MetadataDestination] = dest
+	return c.Send(msg, data)
 }
 
 func (c *Client) SendStream(msg *message.Message, r io.Reader, length int64) error {
 	if c.conn == nil {
-		return errors.New("not connected")
+		return ErrNotConnected
 	}
 	if r == nil {
 		return errors.New("reader nil")
 	}
-	c.logf("sending stream message: %+v length=%d", msg, length)
-	msg.ContentLength = length
+	p := &message.Payload{Stream: r, Length: length}
+	if err := c.intercept(c.options.SendInterceptors, msg, p); err != nil {
+		return err
+	}
+	if c.fragment(msg, p) {
+		return c.sendFragmented(msg, p)
+	}
+	return c.write(msg, p)
+}
+
+// write frames msg with its payload and writes it to the connection without
+// running interceptors.
+func (c *Client) write(msg *message.Message, p *message.Payload) error {
+	return c.writeOn("", msg, p)
+}
+
+// writeOn is like write but queues the frame on a logical channel when
+// multiplexing is enabled.
+func (c *Client) writeOn(channel string, msg *message.Message, p *message.Payload) error {
+	if !p.IsStream() {
+		p.Length = int64(len(p.Data))
+	}
+	if p.IsStream() {
+		c.logf("sending stream message: %+v length=%d", msg, p.Length)
+	} else {
+		c.logf("sending message: %+v length=%d", msg, p.Length)
+	}
+	// a reused message may still carry the checksum of an earlier payload
+	msg.Checksum = ""
+	if c.options.Checksums {
+		sum, err := message.ChecksumPayload(p)
+		if err != nil {
+			return err
+		}
+		msg.Checksum = sum
+	}
+	msg.ContentLength = p.Length
 	msg.TimestampUtc = time.Now().UTC()
 	header, err := message.BuildHeader(msg)
 	if err != nil {
 		return err
 	}
+	if c.mux != nil {
+		data := p.Data
+		if p.IsStream() {
+			data = make([]byte, p.Length)
+			if _, err := io.ReadFull(p.Stream, data); err != nil {
+				return err
+			}
+		}
+		if err := c.mux.Write(channel, header, data); err != nil {
+			c.report(err)
+			return err
+		}
+	} else if err := c.writeDirect(header, p); err != nil {
+		c.report(err)
+		return err
+	}
+	c.stats.IncrementSentMessages()
+	c.stats.AddSentBytes(int64(len(header)) + p.Length)
+	c.logf("sent %d bytes", int64(len(header))+p.Length)
+	return nil
+}
+
+func (c *Client) writeDirect(header []byte, p *message.Payload) error {
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
 	if _, err := c.conn.Write(header); err != nil {
 		return err
 	}
-	if length > 0 {
-		if _, err := io.CopyN(c.conn, r, length); err != nil {
+	if p.IsStream() {
+		if p.Length > 0 {
+			if _, err := io.CopyN(c.conn, p.Stream, p.Length); err != nil {
+				return err
+			}
+		}
+	} else if len(p.Data) > 0 {
+		if _, err := c.conn.Write(p.Data); err != nil {
 			return err
 		}
 	}
-	c.stats.IncrementSentMessages()
-	c.stats.AddSentBytes(int64(len(header)) + length)
-	c.logf("sent %d bytes", int64(len(header))+length)
 	return nil
 }
 
+// verify checks a received payload against the checksum in its header.
+func (c *Client) verify(msg *message.Message, data []byte) error {
+	if !message.VerifyChecksum(msg, data) {
+		c.stats.IncrementChecksumMismatches()
+		c.logf("checksum mismatch: %+v", msg)
+		return message.ErrChecksumMismatch
+	}
+	msg.Checksum = ""
+	return nil
+}
+
+// verifyStream wraps a received stream so that it is checked against the
+// checksum in its header when fully read.
+func (c *Client) verifyStream(msg *message.Message, r io.Reader) io.Reader {
+	if msg.Checksum == "" {
+		return r
+	}
+	want := msg.Checksum
+	msg.Checksum = ""
+	return message.VerifyReader(r, want, c.stats.IncrementChecksumMismatches)
+}
+
+// intercept runs chain in order, stopping at the first error.
+func (c *Client) intercept(chain []Interceptor, msg *message.Message, p *message.Payload) error {
+	for _, fn := range chain {
+		if err := fn(msg, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reject answers a message refused by a receive interceptor with a
+// StatusFailure frame. Dropped messages, failures and sync responses are
+// never answered so that two peers cannot bounce rejections back and forth.
+func (c *Client) reject(msg *message.Message, err error) {
+	if errors.Is(err, message.ErrDropped) || msg.Status == message.StatusFailure || msg.SyncResponse {
+		c.logf("dropped message: %v", err)
+		return
+	}
+	resp := &message.Message{
+		Status:           message.StatusFailure,
+		Metadata:         map[string]any{message.MetadataError: err.Error()},
+		SyncResponse:     msg.SyncRequest,
+		ConversationGUID: msg.ConversationGUID,
+	}
+	if werr := c.write(resp, &message.Payload{}); werr != nil {
+		c.logf("reject reply failed: %v", werr)
+	}
+}
+
+// SendSync sends msg with data and waits for the matching sync response or
+// for ctx to be done. It fails with ErrNotConnected if the connection closes
+// first. The deadline of ctx, if any, is sent as msg.ExpirationUtc unless
+// one is already set.
 func (c *Client) SendSync(ctx context.Context, msg *message.Message, data []byte) (*message.Message, []byte, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	if d, ok := ctx.Deadline(); ok && msg.ExpirationUtc == nil {
+		d = d.UTC()
+		msg.ExpirationUtc = &d
+	}
 	guid := msg.ConversationGUID
 	if guid == "" {
 		guid = newGUID()
@@ -256,7 +433,8 @@ func (c *Client) SendSync(ctx context.Context, msg *message.Message, data []byte
 }
 
 func (c *Client) readLoop() {
-	defer c.Disconnect()
+	reason := message.DisconnectNormal
+	defer func() { c.disconnect(reason) }()
 	for {
 		select {
 		case <-c.done:
@@ -265,17 +443,36 @@ func (c *Client) readLoop() {
 		}
 		msg, err := message.ParseHeader(c.conn)
 		if err != nil {
-			if err != io.EOF {
-				// handle error
-			}
+			c.report(err)
+			reason = disconnectReason(err)
 			return
 		}
 		c.logf("received header: %+v", msg)
-		if c.callbacks.OnStream != nil && c.callbacks.OnMessage == nil && !msg.SyncResponse {
+		if sender, ok := msg.Metadata[message.MetadataSender].(string); ok {
+			msg.SenderGUID = sender
+		}
+		if chunk.IsFrame(msg) {
+			if !c.readChunk(msg) {
+				reason = message.DisconnectIOError
+				return
+			}
+			continue
+		}
+		if r, ok := closingStatuses[msg.Status]; ok {
+			c.logf("server closed the connection: %s", msg.Status)
+			reason = r
+			return
+		}
+		if c.callbacks.OnStream != nil && c.callbacks.OnMessage == nil && !msg.SyncResponse && msg.Metadata[message.MetadataPubSub] == nil && !filexfer.IsTransfer(msg) {
 			lr := &io.LimitedReader{R: c.conn, N: msg.ContentLength}
 			c.stats.IncrementReceivedMessages()
 			c.stats.AddReceivedBytes(msg.ContentLength)
-			c.callbacks.OnStream(msg, lr)
+			p := &message.Payload{Stream: c.verifyStream(msg, lr), Length: msg.ContentLength}
+			if err := c.intercept(c.options.ReceiveInterceptors, msg, p); err != nil {
+				c.reject(msg, err)
+			} else {
+				c.callbacks.OnStream(msg, p.Stream)
+			}
 			if lr.N > 0 {
 				io.CopyN(io.Discard, c.conn, lr.N)
 			}
@@ -286,26 +483,43 @@ func (c *Client) readLoop() {
 		}
 		payload := make([]byte, msg.ContentLength)
 		if _, err := io.ReadFull(c.conn, payload); err != nil {
+			c.report(err)
+			reason = disconnectReason(err)
 			return
 		}
 		c.logf("received %d bytes", len(payload))
 		c.stats.IncrementReceivedMessages()
 		c.stats.AddReceivedBytes(int64(len(payload)))
+		c.mu.Lock()
+		c.lastReceived = time.Now()
+		c.mu.Unlock()
+		p := &message.Payload{Data: payload, Length: int64(len(payload))}
+		ierr := c.verify(msg, p.Data)
+		if ierr == nil {
+			ierr = c.intercept(c.options.ReceiveInterceptors, msg, p)
+		}
 		if msg.SyncResponse && msg.ConversationGUID != "" {
-			if val, ok := c.respMap.Load(msg.ConversationGUID); ok {
+			if val, ok := c.respMap.LoadAndDelete(msg.ConversationGUID); ok {
 				ch := val.(chan *response)
-				c.respMap.Delete(msg.ConversationGUID)
-				ch <- &response{msg: msg, data: payload}
+				ch <- &response{msg: msg, data: p.Data, err: ierr}
 				close(ch)
 				continue
 			}
 		}
+		if ierr != nil {
+			c.reject(msg, ierr)
+			continue
+		}
+		if c.dispatchPubSub(msg, p.Data) {
+			continue
+		}
+		if filexfer.IsTransfer(msg) {
+			go c.handleFile(msg, p.Data)
+			continue
+		}
 		if c.callbacks.OnMessage != nil {
-			go c.callbacks.OnMessage(msg, payload)
+			go c.callbacks.OnMessage(msg, p.Data)
 		}
-		c.mu.Lock()
-		c.lastReceived = time.Now()
-		c.mu.Unlock()
 	}
 }
 
@@ -319,7 +533,9 @@ func (c *Client) idleMonitor() {
 			last := c.lastReceived
 			c.mu.Unlock()
 			if time.Since(last) > c.options.IdleTimeout {
-				c.Disconnect()
+				c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+				c.write(&message.Message{Status: message.StatusTimeout}, &message.Payload{})
+				c.disconnect(message.DisconnectIdleTimeout)
 				return
 			}
 		case <-c.done:
@@ -328,6 +544,25 @@ func (c *Client) idleMonitor() {
 	}
 }
 
+// dial opens the transport connection to Addr.
+func (c *Client) dial() (net.Conn, error) {
+	if c.options.Dialer != nil {
+		ctx := context.Background()
+		if c.options.ConnectTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.options.ConnectTimeout)
+			defer cancel()
+		}
+		return c.options.Dialer(ctx, c.Addr)
+	}
+	d := net.Dialer{Timeout: c.options.ConnectTimeout}
+	if c.options.KeepAlive.Enable {
+		d.KeepAlive = c.options.KeepAlive.Time
+	}
+	network, addr := transport.Split(c.Addr)
+	return d.Dial(network, addr)
+}
+
 func newGUID() string {
 	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
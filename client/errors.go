@@ -0,0 +1,81 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+var (
+	// ErrNotConnected is returned by sends on a client that is not
+	// connected.
+	ErrNotConnected = errors.New("not connected")
+
+	// ErrAuthFailed is returned by Connect when the server does not accept
+	// the preshared key.
+	ErrAuthFailed = errors.New("authentication failed")
+
+	// ErrRegistrationFailed is returned by Connect when the server does not
+	// complete registration.
+	ErrRegistrationFailed = errors.New("registration failed")
+
+	// ErrClosed is returned by Connect on a Failover or Pool that has been
+	// disconnected.
+	ErrClosed = errors.New("client closed")
+)
+
+// RejectedError is returned by Connect when the server refuses the
+// connection and says why.
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return "connection rejected: " + e.Reason
+}
+
+// rejection returns a *RejectedError for a StatusFailure frame received
+// while connecting, or nil for any other message.
+func rejection(msg *message.Message) error {
+	if msg.Status != message.StatusFailure {
+		return nil
+	}
+	reason, _ := msg.Metadata[message.MetadataError].(string)
+	return &RejectedError{Reason: reason}
+}
+
+// report passes err to Callbacks.OnError unless it only signals that the
+// connection was closed.
+func (c *Client) report(err error) {
+	if c.callbacks.OnError == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return
+	}
+	c.callbacks.OnError(err)
+}
+
+// closingStatuses maps the status frames a server sends before closing the
+// connection to the reason reported to OnDisconnect.
+var closingStatuses = map[message.MessageStatus]message.DisconnectReason{
+	message.StatusRemoved:  message.DisconnectRemoved,
+	message.StatusShutdown: message.DisconnectShutdown,
+	message.StatusTimeout:  message.DisconnectIdleTimeout,
+}
+
+// disconnectReason classifies the error that ended a connection.
+func disconnectReason(err error) message.DisconnectReason {
+	var perr *message.ProtocolError
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+		return message.DisconnectNormal
+	case errors.As(err, &perr):
+		return message.DisconnectProtocolError
+	case errors.Is(err, syscall.ETIMEDOUT):
+		// keepalive probes went unanswered
+		return message.DisconnectHeartbeatLost
+	default:
+		return message.DisconnectIOError
+	}
+}
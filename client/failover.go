@@ -0,0 +1,416 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// Strategy chooses the order in which a Failover tries its endpoints.
+type Strategy int
+
+const (
+	// RoundRobin starts each attempt one endpoint further along the list.
+	RoundRobin Strategy = iota
+
+	// Random tries the endpoints in random order.
+	Random
+
+	// LeastLatency tries the endpoint that connected fastest last time
+	// first. Endpoints without a measurement are tried before the rest.
+	LeastLatency
+)
+
+// FailoverOptions configures a Failover.
+type FailoverOptions struct {
+	// Options apply to every connection.
+	Options
+
+	// Strategy picks the endpoint to connect to.
+	Strategy Strategy
+
+	// Resolver, if set, is called before every connection attempt and its
+	// result replaces the endpoint list, so replicas can come from DNS or
+	// service discovery. The context expires after ConnectTimeout.
+	Resolver func(ctx context.Context) ([]string, error)
+
+	// Cooldown is how long an endpoint that failed is tried only after all
+	// healthy endpoints. Zero uses ten seconds.
+	Cooldown time.Duration
+
+	// Reconnect connects to the next endpoint when the active connection
+	// is lost. Disconnect stops it.
+	Reconnect bool
+
+	// RetryInterval is the pause between attempts once every endpoint has
+	// failed while reconnecting. Zero uses one second.
+	RetryInterval time.Duration
+}
+
+// DefaultFailoverOptions returns DefaultOptions with round-robin selection
+// and automatic reconnection.
+func DefaultFailoverOptions() FailoverOptions {
+	return FailoverOptions{
+		Options:       DefaultOptions(),
+		Strategy:      RoundRobin,
+		Cooldown:      10 * time.Second,
+		Reconnect:     true,
+		RetryInterval: time.Second,
+	}
+}
+
+// EndpointStatus describes the health of one endpoint.
+type EndpointStatus struct {
+	Addr   string
+	Active bool
+
+	// Failures counts consecutive failed connections or lost connections.
+	Failures  int
+	LastError error
+
+	// CooldownUntil is when a failed endpoint is treated as healthy again.
+	CooldownUntil time.Time
+
+	// Latency is how long the last successful connect took, including the
+	// TLS handshake and authentication.
+	Latency time.Duration
+}
+
+type endpointHealth struct {
+	failures  int
+	lastError error
+	until     time.Time
+	latency   time.Duration
+}
+
+// Failover is a client for a set of replicas. It connects to one endpoint at
+// a time, chosen by the configured Strategy, and moves to the next endpoint
+// when connecting fails or the connection is lost. Endpoints that fail are
+// put in cooldown and tried last.
+//
+// Each connection is a separate Client, so per-connection state such as
+// subscriptions and ReceiveFile must be restored in OnConnect, which is
+// called after every successful connect once Client returns the new
+// connection.
+type Failover struct {
+	tlsConf   *tls.Config
+	callbacks Callbacks
+	options   FailoverOptions
+
+	mu        sync.Mutex
+	endpoints []string
+	health    map[string]*endpointHealth
+	next      int
+	current   *Client
+	active    string
+	closed    bool
+	done      chan struct{}
+}
+
+// NewFailover returns a Failover for endpoints. The list may be empty when
+// opts.Resolver is set.
+func NewFailover(endpoints []string, tlsConf *tls.Config, cb Callbacks, opts *FailoverOptions) *Failover {
+	if opts == nil {
+		defaultOpts := DefaultFailoverOptions()
+		opts = &defaultOpts
+	}
+	return &Failover{
+		tlsConf:   tlsConf,
+		callbacks: cb,
+		options:   *opts,
+		endpoints: slices.Clone(endpoints),
+		health:    make(map[string]*endpointHealth),
+		done:      make(chan struct{}),
+	}
+}
+
+// Connect connects to the first endpoint that accepts, trying each once. The
+// returned error joins the failures of every endpoint.
+func (f *Failover) Connect() error {
+	f.mu.Lock()
+	switch {
+	case f.closed:
+		f.mu.Unlock()
+		return ErrClosed
+	case f.current != nil:
+		f.mu.Unlock()
+		return errors.New("already connected")
+	}
+	f.mu.Unlock()
+	return f.connect()
+}
+
+func (f *Failover) connect() error {
+	if err := f.resolve(); err != nil {
+		return err
+	}
+	order := f.order(time.Now())
+	if len(order) == 0 {
+		return errors.New("no endpoints")
+	}
+	var errs []error
+	for _, addr := range order {
+		var cli *Client
+		cb := f.callbacks
+		cb.OnConnect = nil
+		cb.OnDisconnect = func(reason message.DisconnectReason) { f.disconnected(cli, reason) }
+		cli = New(addr, f.tlsConf, cb, &f.options.Options)
+		start := time.Now()
+		if err := cli.Connect(); err != nil {
+			f.fail(addr, err)
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			continue
+		}
+		f.mu.Lock()
+		if !cli.alive() {
+			// lost before it became current, so disconnected ignored it
+			f.mu.Unlock()
+			err := errors.New("connection lost")
+			f.fail(addr, err)
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			continue
+		}
+		if f.closed || f.current != nil {
+			closed := f.closed
+			f.mu.Unlock()
+			cli.Disconnect()
+			if closed {
+				return ErrClosed
+			}
+			return nil
+		}
+		h := f.endpoint(addr)
+		h.failures, h.lastError, h.until, h.latency = 0, nil, time.Time{}, time.Since(start)
+		f.current, f.active = cli, addr
+		f.mu.Unlock()
+		if f.callbacks.OnConnect != nil {
+			go f.callbacks.OnConnect()
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+func (f *Failover) resolve() error {
+	if f.options.Resolver == nil {
+		return nil
+	}
+	ctx := context.Background()
+	if f.options.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.options.ConnectTimeout)
+		defer cancel()
+	}
+	endpoints, err := f.options.Resolver(ctx)
+	if err != nil {
+		return fmt.Errorf("resolve endpoints: %w", err)
+	}
+	f.mu.Lock()
+	f.endpoints = endpoints
+	f.mu.Unlock()
+	return nil
+}
+
+// order returns the endpoints in the order to try them: healthy endpoints
+// as chosen by the strategy, then those in cooldown, soonest to recover
+// first.
+func (f *Failover) order(now time.Time) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	eps := slices.Clone(f.endpoints)
+	if len(eps) == 0 {
+		return nil
+	}
+	switch f.options.Strategy {
+	case Random:
+		rand.Shuffle(len(eps), func(i, j int) { eps[i], eps[j] = eps[j], eps[i] })
+	case LeastLatency:
+		slices.SortStableFunc(eps, func(a, b string) int {
+			return cmp.Compare(f.endpoint(a).latency, f.endpoint(b).latency)
+		})
+	default:
+		n := f.next % len(eps)
+		f.next++
+		eps = append(eps[n:], eps[:n]...)
+	}
+	var ready, cooling []string
+	for _, addr := range eps {
+		if now.Before(f.endpoint(addr).until) {
+			cooling = append(cooling, addr)
+		} else {
+			ready = append(ready, addr)
+		}
+	}
+	slices.SortStableFunc(cooling, func(a, b string) int {
+		return f.endpoint(a).until.Compare(f.endpoint(b).until)
+	})
+	return append(ready, cooling...)
+}
+
+// endpoint returns the health record of addr. The caller must hold f.mu.
+func (f *Failover) endpoint(addr string) *endpointHealth {
+	h := f.health[addr]
+	if h == nil {
+		h = &endpointHealth{}
+		f.health[addr] = h
+	}
+	return h
+}
+
+func (f *Failover) fail(addr string, err error) {
+	cooldown := f.options.Cooldown
+	if cooldown <= 0 {
+		cooldown = 10 * time.Second
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h := f.endpoint(addr)
+	h.failures++
+	h.lastError = err
+	h.until = time.Now().Add(cooldown)
+}
+
+// disconnected handles the loss of the connection made by cli.
+func (f *Failover) disconnected(cli *Client, reason message.DisconnectReason) {
+	f.mu.Lock()
+	if cli == nil || f.current != cli {
+		f.mu.Unlock()
+		return
+	}
+	f.current, f.active = nil, ""
+	closed := f.closed
+	f.mu.Unlock()
+	if !closed && reason != message.DisconnectIdleTimeout {
+		f.fail(cli.Addr, fmt.Errorf("disconnected: %s", reason))
+	}
+	if f.callbacks.OnDisconnect != nil {
+		f.callbacks.OnDisconnect(reason)
+	}
+	if !closed && f.options.Reconnect {
+		go f.reconnect()
+	}
+}
+
+func (f *Failover) reconnect() {
+	interval := f.options.RetryInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for {
+		err := f.connect()
+		if err == nil || errors.Is(err, ErrClosed) {
+			return
+		}
+		f.logf("reconnect failed: %v", err)
+		select {
+		case <-time.After(interval):
+		case <-f.done:
+			return
+		}
+	}
+}
+
+func (f *Failover) logf(format string, args ...any) {
+	if f.options.Logger != nil && f.options.DebugMessages {
+		f.options.Logger(format, args...)
+	}
+}
+
+// Disconnect closes the active connection and stops reconnecting. A
+// Failover cannot be connected again afterwards.
+func (f *Failover) Disconnect() {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return
+	}
+	f.closed = true
+	close(f.done)
+	cli := f.current
+	f.mu.Unlock()
+	if cli != nil {
+		cli.Disconnect()
+	}
+}
+
+// Client returns the connection to the active endpoint, or nil while
+// disconnected.
+func (f *Failover) Client() *Client {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.current
+}
+
+// Active returns the address of the endpoint currently connected to, or ""
+// while disconnected.
+func (f *Failover) Active() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.active
+}
+
+// Endpoints reports the health of every known endpoint.
+func (f *Failover) Endpoints() []EndpointStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]EndpointStatus, 0, len(f.endpoints))
+	for _, addr := range f.endpoints {
+		h := f.endpoint(addr)
+		out = append(out, EndpointStatus{
+			Addr:          addr,
+			Active:        addr == f.active,
+			Failures:      h.failures,
+			LastError:     h.lastError,
+			CooldownUntil: h.until,
+			Latency:       h.latency,
+		})
+	}
+	return out
+}
+
+// Send transmits msg with data on the active connection.
+func (f *Failover) Send(msg *message.Message, data []byte) error {
+	c := f.Client()
+	if c == nil {
+		return ErrNotConnected
+	}
+	return c.Send(msg, data)
+}
+
+// SendStream transmits length bytes from r on the active connection.
+func (f *Failover) SendStream(msg *message.Message, r io.Reader, length int64) error {
+	c := f.Client()
+	if c == nil {
+		return ErrNotConnected
+	}
+	return c.SendStream(msg, r, length)
+}
+
+// SendSync sends msg on the active connection and waits for the response.
+// A request in flight when the connection is lost fails with
+// ErrNotConnected and is not retried on the next endpoint.
+func (f *Failover) SendSync(ctx context.Context, msg *message.Message, data []byte) (*message.Message, []byte, error) {
+	c := f.Client()
+	if c == nil {
+		return nil, nil, ErrNotConnected
+	}
+	return c.SendSync(ctx, msg, data)
+}
+
+// SendChunked streams r on the active connection.
+func (f *Failover) SendChunked(ctx context.Context, msg *message.Message, r io.Reader) error {
+	c := f.Client()
+	if c == nil {
+		return ErrNotConnected
+	}
+	return c.SendChunked(ctx, msg, r)
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+
+	"github.com/WasimAhmad/watsontcp-go/internal/filexfer"
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// FileHandler is called after a file sent with SendFile has been received,
+// verified and moved to path.
+type FileHandler func(name, path string)
+
+// SendFile transfers the file at path to the server, which must have called
+// ReceiveFile. Data is sent in acknowledged blocks; if the transfer is
+// interrupted, calling SendFile again after reconnecting resumes from the
+// last block the server acknowledged. The server verifies the SHA-256 of the
+// whole file before making it visible.
+func (c *Client) SendFile(ctx context.Context, path string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return filexfer.Send(ctx, path, c.SendSync)
+}
+
+// ReceiveFile accepts files sent by the server with SendFile, storing them in
+// dir and calling handler for each completed file. Partial transfers are kept
+// in dir as hidden ".part" files until they complete.
+func (c *Client) ReceiveFile(dir string, handler FileHandler) {
+	c.filesMu.Lock()
+	c.files = filexfer.NewReceiver(dir)
+	c.fileHandler = handler
+	c.filesMu.Unlock()
+}
+
+// handleFile answers a file transfer step.
+func (c *Client) handleFile(msg *message.Message, data []byte) {
+	c.filesMu.Lock()
+	recv, handler := c.files, c.fileHandler
+	c.filesMu.Unlock()
+	if recv == nil {
+		c.write(filexfer.Reject(msg), &message.Payload{})
+		return
+	}
+	reply, path := recv.Handle(msg, data)
+	if err := c.write(reply, &message.Payload{}); err != nil {
+		c.logf("file transfer reply failed: %v", err)
+	}
+	if path != "" && handler != nil {
+		name, _ := msg.Metadata[message.MetadataFileName].(string)
+		handler(name, path)
+	}
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/WasimAhmad/watsontcp-go/internal/chunk"
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// fragment reports whether a payload should be split into interleavable
+// fragments. Sync responses are kept whole so that they are matched to
+// their request on arrival.
+func (c *Client) fragment(msg *message.Message, p *message.Payload) bool {
+	if c.mux == nil || msg.SyncResponse {
+		return false
+	}
+	size := int64(c.options.ChunkSize)
+	if size <= 0 {
+		size = chunk.DefaultSize
+	}
+	if !p.IsStream() {
+		return int64(len(p.Data)) > size
+	}
+	return p.Length > size
+}
+
+// sendFragmented sends a payload of known length as a chunked stream on its
+// own logical channel.
+func (c *Client) sendFragmented(msg *message.Message, p *message.Payload) error {
+	r := p.Stream
+	if p.IsStream() {
+		r = chunk.Exact(p.Stream, p.Length)
+	} else {
+		p.Length = int64(len(p.Data))
+		r = bytes.NewReader(p.Data)
+	}
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]any)
+	}
+	msg.Metadata[message.MetadataStreamLength] = p.Length
+	c.logf("sending fragmented message: %+v length=%d", msg, p.Length)
+	return c.sendChunks(context.Background(), msg, r)
+}
@@ -1,6 +1,12 @@
 package client
 
-import "time"
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
 
 // Options mirrors a subset of the configuration options exposed in the C#
 // implementation for WatsonTcp clients.
@@ -9,7 +15,8 @@ type Options struct {
 	ConnectTimeout time.Duration
 
 	// IdleTimeout specifies the period of inactivity after which the
-	// connection will be closed. Zero disables idle timeouts.
+	// connection will be closed, telling the server with StatusTimeout.
+	// Zero disables idle timeouts.
 	IdleTimeout time.Duration
 
 	// EvaluationInterval is the interval at which idle timeouts are evaluated.
@@ -18,6 +25,13 @@ type Options struct {
 	// KeepAlive defines TCP keepalive behavior.
 	KeepAlive KeepAlive
 
+	// Dialer, if set, opens the connection to Addr instead of the built-in
+	// TCP or Unix socket dialer, for custom tunnels or in-memory transports
+	// such as net.Pipe. The context expires after ConnectTimeout. TLS is
+	// layered on the returned connection when a TLS config is given, and
+	// KeepAlive is not applied.
+	Dialer func(ctx context.Context, addr string) (net.Conn, error)
+
 	// PresharedKey is required by the server for authentication.
 	PresharedKey string
 
@@ -29,8 +43,43 @@ type Options struct {
 	// DebugMessages enables logging of send and receive operations when a
 	// Logger is provided.
 	DebugMessages bool
+
+	// SendInterceptors run in order on every outbound message before it is
+	// written. An error aborts the send and is returned to the caller.
+	SendInterceptors []Interceptor
+
+	// ReceiveInterceptors run in order on every inbound message before it
+	// is delivered to callbacks or to a waiting SendSync. Returning
+	// message.ErrDropped discards the message; any other error replies to
+	// the server with StatusFailure and the error text in metadata.
+	ReceiveInterceptors []Interceptor
+
+	// ChunkSize is the maximum payload of each frame sent by SendChunked.
+	// Zero uses 64 KiB.
+	ChunkSize int
+
+	// Multiplex schedules outgoing frames through per-connection logical
+	// channels with round-robin fairness. Payloads larger than ChunkSize are
+	// fragmented into chunked streams on their own channel, so small
+	// messages are not held up behind bulk transfers. Fragmented payloads
+	// are only understood by peers running this library.
+	Multiplex bool
+
+	// Checksums adds a CRC32C of the payload to the header of every message
+	// sent. Received checksums are always verified; byte payloads that do
+	// not match are rejected and streams fail with
+	// message.ErrChecksumMismatch at EOF. Streams that do not implement
+	// io.Seeker are buffered in memory to compute the checksum, so prefer
+	// SendChunked for large unseekable streams; each chunk carries its own
+	// checksum.
+	Checksums bool
 }
 
+// Interceptor inspects or modifies a message and its payload as it passes
+// through the client. It applies uniformly to byte, stream and sync
+// messages.
+type Interceptor func(msg *message.Message, p *message.Payload) error
+
 // KeepAlive mirrors WatsonTcp keepalive settings.
 type KeepAlive struct {
 	Enable     bool
@@ -54,5 +103,6 @@ func DefaultOptions() Options {
 		},
 		Logger:        nil,
 		DebugMessages: false,
+		ChunkSize:     64 * 1024,
 	}
 }
@@ -0,0 +1,316 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+	"github.com/WasimAhmad/watsontcp-go/stats"
+)
+
+// PoolOptions configures a Pool.
+type PoolOptions struct {
+	// Options apply to every connection.
+	Options
+
+	// Size is the number of connections to keep open. Zero uses four.
+	Size int
+
+	// LeastPending sends each message on the connection with the fewest
+	// sends in progress instead of rotating through the connections.
+	LeastPending bool
+
+	// RetryInterval is the pause between attempts to replace a broken
+	// connection. Zero uses one second.
+	RetryInterval time.Duration
+}
+
+// DefaultPoolOptions returns DefaultOptions with four round-robin
+// connections.
+func DefaultPoolOptions() PoolOptions {
+	return PoolOptions{
+		Options:       DefaultOptions(),
+		Size:          4,
+		RetryInterval: time.Second,
+	}
+}
+
+type pooledConn struct {
+	cli     *Client
+	pending atomic.Int64
+}
+
+// Pool keeps several connections to one server and spreads sends across
+// them, so goroutines sending in parallel do not queue behind a single
+// connection. Broken connections are replaced in the background.
+//
+// The callbacks are shared by every connection: OnMessage and OnStream
+// receive messages arriving on any of them, and OnConnect and OnDisconnect
+// are called once per connection. The server sees each connection as a
+// separate client.
+type Pool struct {
+	Addr      string
+	TLSConfig *tls.Config
+
+	callbacks Callbacks
+	options   PoolOptions
+
+	mu      sync.Mutex
+	conns   []*pooledConn
+	next    int
+	retired *stats.Statistics
+	// connected is set once Connect has opened at least one connection;
+	// broken ones are replaced in the background from then on.
+	connected bool
+	closed    bool
+	done      chan struct{}
+}
+
+// NewPool returns a Pool of connections to addr.
+func NewPool(addr string, tlsConf *tls.Config, cb Callbacks, opts *PoolOptions) *Pool {
+	if opts == nil {
+		defaultOpts := DefaultPoolOptions()
+		opts = &defaultOpts
+	}
+	size := opts.Size
+	if size <= 0 {
+		size = 4
+	}
+	return &Pool{
+		Addr:      addr,
+		TLSConfig: tlsConf,
+		callbacks: cb,
+		options:   *opts,
+		conns:     make([]*pooledConn, size),
+		retired:   stats.New(),
+		done:      make(chan struct{}),
+	}
+}
+
+// Connect opens the connections. It fails only if none can be opened; any
+// that fail are retried in the background.
+func (p *Pool) Connect() error {
+	p.mu.Lock()
+	switch {
+	case p.closed:
+		p.mu.Unlock()
+		return ErrClosed
+	case p.connected:
+		p.mu.Unlock()
+		return errors.New("already connected")
+	}
+	p.connected = true
+	p.mu.Unlock()
+	var errs []error
+	for i := range p.conns {
+		if err := p.dial(i); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(p.conns) {
+		p.mu.Lock()
+		p.connected = false
+		p.mu.Unlock()
+		return errors.Join(errs...)
+	}
+	for i := range p.conns {
+		if p.slot(i) == nil {
+			go p.replace(i)
+		}
+	}
+	return nil
+}
+
+// dial opens the connection for slot i.
+func (p *Pool) dial(i int) error {
+	var pc *pooledConn
+	cb := p.callbacks
+	cb.OnDisconnect = func(reason message.DisconnectReason) { p.disconnected(i, pc, reason) }
+	pc = &pooledConn{cli: New(p.Addr, p.TLSConfig, cb, &p.options.Options)}
+	if err := pc.cli.Connect(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	switch {
+	case p.closed:
+		p.mu.Unlock()
+		pc.cli.Disconnect()
+		return ErrClosed
+	case !pc.cli.alive():
+		// lost before it took the slot, so disconnected did not replace it
+		p.mu.Unlock()
+		return errors.New("connection lost")
+	}
+	p.conns[i] = pc
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Pool) slot(i int) *pooledConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conns[i]
+}
+
+func (p *Pool) disconnected(i int, pc *pooledConn, reason message.DisconnectReason) {
+	p.mu.Lock()
+	owned := p.conns[i] == pc
+	if owned {
+		p.conns[i] = nil
+	}
+	p.retired.Merge(pc.cli.Statistics())
+	closed := p.closed
+	p.mu.Unlock()
+	if p.callbacks.OnDisconnect != nil {
+		p.callbacks.OnDisconnect(reason)
+	}
+	if owned && !closed {
+		go p.replace(i)
+	}
+}
+
+// replace reconnects slot i until it succeeds or the pool is closed.
+func (p *Pool) replace(i int) {
+	interval := p.options.RetryInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for {
+		select {
+		case <-time.After(interval):
+		case <-p.done:
+			return
+		}
+		err := p.dial(i)
+		if err == nil || errors.Is(err, ErrClosed) {
+			return
+		}
+		if p.options.Logger != nil && p.options.DebugMessages {
+			p.options.Logger("replacing pooled connection failed: %v", err)
+		}
+	}
+}
+
+// Disconnect closes every connection and stops replacing them. A Pool
+// cannot be connected again afterwards.
+func (p *Pool) Disconnect() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	var open []*pooledConn
+	for _, pc := range p.conns {
+		if pc != nil {
+			open = append(open, pc)
+		}
+	}
+	p.mu.Unlock()
+	for _, pc := range open {
+		pc.cli.Disconnect()
+	}
+}
+
+// Connected returns the number of open connections.
+func (p *Pool) Connected() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, pc := range p.conns {
+		if pc != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Statistics returns the totals of every connection the pool has opened,
+// including ones since replaced. The result is a snapshot.
+func (p *Pool) Statistics() *stats.Statistics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	total := p.retired.Clone()
+	for _, pc := range p.conns {
+		if pc != nil {
+			total.Merge(pc.cli.Statistics())
+		}
+	}
+	return total
+}
+
+// pick chooses the connection for the next send and counts the send as
+// pending until done is called.
+func (p *Pool) pick() (cli *Client, done func(), err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var best *pooledConn
+	n := len(p.conns)
+	for k := range n {
+		pc := p.conns[(p.next+k)%n]
+		if pc == nil {
+			continue
+		}
+		if !p.options.LeastPending {
+			best = pc
+			p.next = (p.next + k + 1) % n
+			break
+		}
+		if best == nil || pc.pending.Load() < best.pending.Load() {
+			best = pc
+		}
+	}
+	if best == nil {
+		return nil, nil, ErrNotConnected
+	}
+	best.pending.Add(1)
+	return best.cli, func() { best.pending.Add(-1) }, nil
+}
+
+// Send transmits msg with data on one of the connections.
+func (p *Pool) Send(msg *message.Message, data []byte) error {
+	cli, done, err := p.pick()
+	if err != nil {
+		return err
+	}
+	defer done()
+	return cli.Send(msg, data)
+}
+
+// SendStream transmits length bytes from r on one of the connections.
+func (p *Pool) SendStream(msg *message.Message, r io.Reader, length int64) error {
+	cli, done, err := p.pick()
+	if err != nil {
+		return err
+	}
+	defer done()
+	return cli.SendStream(msg, r, length)
+}
+
+// SendSync sends msg on one of the connections and waits for the response,
+// which arrives on the same connection. It fails with ErrNotConnected if that
+// connection is lost first.
+func (p *Pool) SendSync(ctx context.Context, msg *message.Message, data []byte) (*message.Message, []byte, error) {
+	cli, done, err := p.pick()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+	return cli.SendSync(ctx, msg, data)
+}
+
+// SendChunked streams r on one of the connections.
+func (p *Pool) SendChunked(ctx context.Context, msg *message.Message, r io.Reader) error {
+	cli, done, err := p.pick()
+	if err != nil {
+		return err
+	}
+	defer done()
+	return cli.SendChunked(ctx, msg, r)
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// TestDialLostBeforeStored drops a pooled connection while dial waits for
+// the pool's lock, so the loss is reported before the slot holds the
+// connection. The slot must stay empty for replace to fill.
+func TestDialLostBeforeStored(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	hdr, _ := message.BuildHeader(&message.Message{Status: message.StatusRegisterClient})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write(hdr)
+			conn.Close()
+		}
+	}()
+
+	opts := DefaultPoolOptions()
+	opts.Size = 1
+	p := NewPool(ln.Addr().String(), nil, Callbacks{}, &opts)
+	defer p.Disconnect()
+	p.mu.Lock()
+	done := make(chan error, 1)
+	go func() { done <- p.dial(0) }()
+	time.Sleep(100 * time.Millisecond)
+	p.mu.Unlock()
+	if err := <-done; err == nil {
+		t.Fatalf("dial stored a connection lost before it took the slot")
+	}
+	if p.slot(0) != nil {
+		t.Fatalf("slot holds a dead connection")
+	}
+}
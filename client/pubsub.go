@@ -0,0 +1,79 @@
+package client
+
+import (
+	"github.com/WasimAhmad/watsontcp-go/message"
+	"github.com/WasimAhmad/watsontcp-go/pubsub"
+)
+
+// TopicHandler receives messages published to a subscribed topic.
+type TopicHandler func(topic string, data []byte)
+
+// Subscribe asks the server to deliver messages published to topics
+// matching pattern and registers handler for them. Subscribing again to the
+// same pattern replaces its handler. The server must have PubSub enabled.
+func (c *Client) Subscribe(pattern string, handler TopicHandler) error {
+	if err := pubsub.ValidatePattern(pattern); err != nil {
+		return err
+	}
+	c.subsMu.Lock()
+	if c.subs == nil {
+		c.subs = make(map[string]TopicHandler)
+	}
+	c.subs[pattern] = handler
+	c.subsMu.Unlock()
+	if err := c.Send(pubsubMessage(pubsub.ActionSubscribe, pattern), nil); err != nil {
+		c.subsMu.Lock()
+		delete(c.subs, pattern)
+		c.subsMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Unsubscribe cancels a subscription made with Subscribe.
+func (c *Client) Unsubscribe(pattern string) error {
+	c.subsMu.Lock()
+	delete(c.subs, pattern)
+	c.subsMu.Unlock()
+	return c.Send(pubsubMessage(pubsub.ActionUnsubscribe, pattern), nil)
+}
+
+// Publish sends data to every client subscribed to a pattern matching topic,
+// including this one.
+func (c *Client) Publish(topic string, data []byte) error {
+	if err := pubsub.ValidateTopic(topic); err != nil {
+		return err
+	}
+	return c.Send(pubsubMessage(pubsub.ActionPublish, topic), data)
+}
+
+// dispatchPubSub hands a published message to matching topic handlers. It
+// reports whether any handler received it.
+func (c *Client) dispatchPubSub(msg *message.Message, data []byte) bool {
+	if action, _ := msg.Metadata[message.MetadataPubSub].(string); action != pubsub.ActionMessage {
+		return false
+	}
+	topic, _ := msg.Metadata[message.MetadataTopic].(string)
+	var handlers []TopicHandler
+	c.subsMu.Lock()
+	for p, h := range c.subs {
+		if pubsub.Match(p, topic) {
+			handlers = append(handlers, h)
+		}
+	}
+	c.subsMu.Unlock()
+	for _, h := range handlers {
+		go h(topic, data)
+	}
+	return len(handlers) > 0
+}
+
+func pubsubMessage(action, topic string) *message.Message {
+	return &message.Message{
+		Status: message.StatusNormal,
+		Metadata: map[string]any{
+			message.MetadataPubSub: action,
+			message.MetadataTopic:  topic,
+		},
+	}
+}
@@ -1,20 +1,36 @@
 package watsontcpgo_test
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
 	"math/big"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/WasimAhmad/watsontcp-go/client"
+	"github.com/WasimAhmad/watsontcp-go/internal/filexfer"
 	"github.com/WasimAhmad/watsontcp-go/message"
+	"github.com/WasimAhmad/watsontcp-go/pubsub"
 	"github.com/WasimAhmad/watsontcp-go/server"
+	"github.com/WasimAhmad/watsontcp-go/websocket"
 )
 
 func newTLSConfig() (*tls.Config, error) {
@@ -131,7 +147,7 @@ func TestClientIdleDisconnect(t *testing.T) {
 	opts := client.DefaultOptions()
 	opts.IdleTimeout = 200 * time.Millisecond
 	opts.EvaluationInterval = 50 * time.Millisecond
-	cli := client.New("127.0.0.1:30101", nil, client.Callbacks{OnDisconnect: func() { close(disc) }}, &opts)
+	cli := client.New("127.0.0.1:30101", nil, client.Callbacks{OnDisconnect: func(message.DisconnectReason) { close(disc) }}, &opts)
 	if err := cli.Connect(); err != nil {
 		t.Fatalf("connect: %v", err)
 	}
@@ -149,7 +165,7 @@ func TestServerIdleDisconnect(t *testing.T) {
 	opts := server.DefaultOptions()
 	opts.IdleTimeout = 200 * time.Millisecond
 	opts.CheckInterval = 50 * time.Millisecond
-	cb := server.Callbacks{OnDisconnect: func(id string) { close(disc) }}
+	cb := server.Callbacks{OnDisconnect: func(id string, reason message.DisconnectReason) { close(disc) }}
 	srv := server.New("127.0.0.1:30102", nil, cb, &opts)
 	if err := srv.Start(); err != nil {
 		t.Fatalf("server start: %v", err)
@@ -209,9 +225,9 @@ func TestPresharedKeyFailure(t *testing.T) {
 	cliOpts := client.DefaultOptions()
 	cliOpts.PresharedKey = "wrong"
 	cli := client.New("127.0.0.1:30111", nil, client.Callbacks{}, &cliOpts)
-	if err := cli.Connect(); err == nil {
+	if err := cli.Connect(); !errors.Is(err, client.ErrAuthFailed) {
 		cli.Disconnect()
-		t.Fatalf("expected auth failure")
+		t.Fatalf("expected auth failure, got %v", err)
 	}
 }
 
@@ -259,3 +275,1933 @@ func TestIPBlocking(t *testing.T) {
 		}
 	}
 }
+
+func TestInterceptors(t *testing.T) {
+	received := make(chan string, 1)
+	opts := server.DefaultOptions()
+	opts.ReceiveInterceptors = []server.Interceptor{
+		func(id string, msg *message.Message, p *message.Payload) error {
+			if msg.Metadata["deny"] != nil {
+				return errors.New("denied")
+			}
+			if msg.Metadata["drop"] != nil {
+				return message.ErrDropped
+			}
+			return nil
+		},
+	}
+	cb := server.Callbacks{OnMessage: func(id string, msg *message.Message, data []byte) {
+		received <- msg.Metadata["stamp"].(string) + ":" + string(data)
+	}}
+	srv := server.New("127.0.0.1:30130", nil, cb, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cliOpts := client.DefaultOptions()
+	cliOpts.SendInterceptors = []client.Interceptor{
+		func(msg *message.Message, p *message.Payload) error {
+			if msg.Metadata == nil {
+				msg.Metadata = map[string]any{}
+			}
+			msg.Metadata["stamp"] = "go"
+			p.Data = bytes.ToUpper(p.Data)
+			return nil
+		},
+	}
+	cli := client.New("127.0.0.1:30130", nil, client.Callbacks{}, &cliOpts)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, _, err := cli.SendSync(ctx, &message.Message{Metadata: map[string]any{"deny": true}}, []byte("x"))
+	if err != nil {
+		t.Fatalf("SendSync: %v", err)
+	}
+	if resp.Status != message.StatusFailure || resp.Metadata[message.MetadataError] != "denied" {
+		t.Fatalf("expected failure reply, got %+v", resp)
+	}
+
+	if err := cli.Send(&message.Message{Metadata: map[string]any{"drop": true}}, []byte("dropped")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if err := cli.Send(&message.Message{}, []byte("hi")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != "go:HI" {
+			t.Fatalf("unexpected message %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("message not received")
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.PubSub = true
+	ids := make(chan string, 2)
+	srv := server.New("127.0.0.1:30150", nil, server.Callbacks{
+		OnConnect: func(id string, conn net.Conn) { ids <- id },
+	}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	sub := client.New("127.0.0.1:30150", nil, client.Callbacks{}, nil)
+	if err := sub.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	subID := <-ids
+	got := make(chan string, 1)
+	if err := sub.Subscribe("orders.>", func(topic string, data []byte) {
+		got <- topic + ":" + string(data)
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	waitFor(t, func() bool { return len(srv.Subscriptions(subID)) == 1 })
+
+	pub := client.New("127.0.0.1:30150", nil, client.Callbacks{}, nil)
+	if err := pub.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer pub.Disconnect()
+	if err := pub.Publish("orders.eu.created", []byte("42")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	select {
+	case msg := <-got:
+		if msg != "orders.eu.created:42" {
+			t.Fatalf("unexpected message %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("publish not delivered")
+	}
+
+	sub.Disconnect()
+	waitFor(t, func() bool { return len(srv.Subscriptions(subID)) == 0 })
+}
+
+// TestPubSubStalledSubscriber publishes to a subscriber that never reads.
+// The publisher's connection and the other subscribers must not wait on it.
+func TestPubSubStalledSubscriber(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.PubSub = true
+	var srv *server.Server
+	srv = server.New("127.0.0.1:30151", nil, server.Callbacks{
+		OnMessage: func(id string, msg *message.Message, data []byte) {
+			if msg.SyncRequest {
+				srv.Send(id, &message.Message{SyncResponse: true, ConversationGUID: msg.ConversationGUID}, data)
+			}
+		},
+	}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	stalled, err := net.Dial("tcp", "127.0.0.1:30151")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer stalled.Close()
+	hdr, _ := message.BuildHeader(&message.Message{Metadata: map[string]any{
+		message.MetadataPubSub: pubsub.ActionSubscribe,
+		message.MetadataTopic:  "feed",
+	}})
+	if _, err := stalled.Write(hdr); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	waitFor(t, func() bool { return len(srv.Subscriptions(stalled.LocalAddr().String())) == 1 })
+
+	const count = 100
+	got := make(chan struct{}, count)
+	sub := client.New("127.0.0.1:30151", nil, client.Callbacks{}, nil)
+	if err := sub.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer sub.Disconnect()
+	sub.Subscribe("feed", func(topic string, data []byte) { got <- struct{}{} })
+
+	pub := client.New("127.0.0.1:30151", nil, client.Callbacks{}, nil)
+	if err := pub.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer pub.Disconnect()
+	time.Sleep(100 * time.Millisecond)
+
+	// enough data to fill the stalled subscriber's socket buffers
+	payload := make([]byte, 256*1024)
+	for i := 0; i < count; i++ {
+		if err := pub.Publish("feed", payload); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, data, err := pub.SendSync(ctx, &message.Message{}, []byte("ping")); err != nil || string(data) != "ping" {
+		t.Fatalf("publisher blocked by stalled subscriber: %q %v", data, err)
+	}
+	for i := 0; i < count; i++ {
+		select {
+		case <-got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("subscriber received %d of %d publications", i, count)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Relay = true
+	opts.RelayAuthorizer = func(from, to string, msg *message.Message) bool {
+		return msg.Metadata["forbidden"] == nil
+	}
+	ids := make(chan string, 2)
+	srv := server.New("127.0.0.1:30160", nil, server.Callbacks{
+		OnConnect: func(id string, conn net.Conn) { ids <- id },
+	}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	a := client.New("127.0.0.1:30160", nil, client.Callbacks{}, nil)
+	if err := a.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer a.Disconnect()
+	aID := <-ids
+
+	senders := make(chan string, 1)
+	var b *client.Client
+	b = client.New("127.0.0.1:30160", nil, client.Callbacks{
+		OnMessage: func(msg *message.Message, data []byte) {
+			senders <- msg.SenderGUID
+			if msg.Metadata["slow"] != nil {
+				time.Sleep(200 * time.Millisecond)
+			}
+			if msg.SyncRequest {
+				resp := &message.Message{SyncResponse: true, ConversationGUID: msg.ConversationGUID}
+				b.Send(resp, append(data, '!'))
+			}
+		},
+	}, nil)
+	if err := b.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer b.Disconnect()
+	bID := <-ids
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	msg := &message.Message{Metadata: map[string]any{
+		message.MetadataDestination: bID,
+		message.MetadataSender:      "spoofed",
+	}}
+	resp, data, err := a.SendSync(ctx, msg, []byte("hi"))
+	if err != nil {
+		t.Fatalf("SendSync: %v", err)
+	}
+	if string(data) != "hi!" || resp.SenderGUID != bID {
+		t.Fatalf("unexpected response %q from %q", data, resp.SenderGUID)
+	}
+	if sender := <-senders; sender != aID {
+		t.Fatalf("expected sender %s got %s", aID, sender)
+	}
+
+	msg = &message.Message{Metadata: map[string]any{
+		message.MetadataDestination: bID,
+		"forbidden":                 true,
+	}}
+	resp, _, err = a.SendSync(ctx, msg, nil)
+	if err != nil {
+		t.Fatalf("SendSync: %v", err)
+	}
+	if resp.Status != message.StatusFailure {
+		t.Fatalf("expected relay to be refused")
+	}
+
+	// a conversation id already pending to the same destination is refused
+	// instead of stealing the first caller's reply
+	c := client.New("127.0.0.1:30160", nil, client.Callbacks{}, nil)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Disconnect()
+	<-ids
+	first := make(chan string, 1)
+	go func() {
+		msg := &message.Message{ConversationGUID: "dup", Metadata: map[string]any{
+			message.MetadataDestination: bID,
+			"slow":                      true,
+		}}
+		_, data, err := a.SendSync(ctx, msg, []byte("one"))
+		if err != nil {
+			first <- err.Error()
+			return
+		}
+		first <- string(data)
+	}()
+	<-senders
+	msg = &message.Message{ConversationGUID: "dup", Metadata: map[string]any{message.MetadataDestination: bID}}
+	resp, _, err = c.SendSync(ctx, msg, []byte("two"))
+	if err != nil || resp.Status != message.StatusFailure {
+		t.Fatalf("expected duplicate conversation to be refused: %v %+v", err, resp)
+	}
+	if got := <-first; got != "one!" {
+		t.Fatalf("first caller got %q", got)
+	}
+}
+
+// TestRelayExpiry relays sync requests to a client that stays connected but
+// does not answer. Their routes must expire with the caller's deadline, or
+// after RelayTimeout, so the conversation id can be used again.
+func TestRelayExpiry(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Relay = true
+	opts.CheckInterval = 50 * time.Millisecond
+	opts.RelayTimeout = 300 * time.Millisecond
+	srv := server.New("127.0.0.1:30161", nil, server.Callbacks{}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	var b *client.Client
+	b = client.New("127.0.0.1:30161", nil, client.Callbacks{
+		OnMessage: func(msg *message.Message, data []byte) {
+			if msg.SyncRequest && string(data) == "answer" {
+				b.Send(&message.Message{SyncResponse: true, ConversationGUID: msg.ConversationGUID}, data)
+			}
+		},
+	}, nil)
+	if err := b.Connect(); err != nil {
+		t.Fatalf("connect b: %v", err)
+	}
+	defer b.Disconnect()
+	waitFor(t, func() bool { return len(srv.ListClients()) == 1 })
+	bID := srv.ListClients()[0]
+
+	a := client.New("127.0.0.1:30161", nil, client.Callbacks{}, nil)
+	if err := a.Connect(); err != nil {
+		t.Fatalf("connect a: %v", err)
+	}
+	defer a.Disconnect()
+	send := func(ctx context.Context, data string) (*message.Message, error) {
+		msg := &message.Message{ConversationGUID: "conv", Metadata: map[string]any{message.MetadataDestination: bID}}
+		resp, _, err := a.SendSync(ctx, msg, []byte(data))
+		return resp, err
+	}
+	answered := func() {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if resp, err := send(ctx, "answer"); err != nil || resp.Status == message.StatusFailure {
+			t.Fatalf("conversation id still pending: %v %+v", err, resp)
+		}
+	}
+
+	// the caller's deadline travels with the request
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := send(ctx, "ignore"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unanswered request: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	answered()
+
+	// without a deadline the route lasts RelayTimeout
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err := send(ctx, "ignore"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unanswered request: %v", err)
+	}
+	time.Sleep(400 * time.Millisecond)
+	answered()
+}
+
+func TestRelayChecksums(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Relay = true
+	opts.Checksums = true
+	// interceptors wrapping the stream must not make the server buffer it
+	wrap := func(id string, msg *message.Message, p *message.Payload) error {
+		if p.IsStream() {
+			p.Stream = struct{ io.Reader }{p.Stream}
+		}
+		return nil
+	}
+	opts.ReceiveInterceptors = []server.Interceptor{wrap}
+	opts.SendInterceptors = []server.Interceptor{wrap}
+	srv := server.New("127.0.0.1:30354", nil, server.Callbacks{}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	started := make(chan struct{})
+	var once sync.Once
+	got := make(chan string, 2)
+	b := client.New("127.0.0.1:30354", nil, client.Callbacks{
+		OnStream: func(msg *message.Message, r io.Reader) {
+			head := make([]byte, 5)
+			if _, err := io.ReadFull(r, head); err != nil {
+				got <- err.Error()
+				return
+			}
+			once.Do(func() { close(started) })
+			rest, err := io.ReadAll(r)
+			if err != nil {
+				got <- err.Error()
+				return
+			}
+			got <- string(head) + string(rest)
+		},
+	}, nil)
+	if err := b.Connect(); err != nil {
+		t.Fatalf("connect b: %v", err)
+	}
+	defer b.Disconnect()
+	waitFor(t, func() bool { return len(srv.ListClients()) == 1 })
+	bID := srv.ListClients()[0]
+
+	a := client.New("127.0.0.1:30354", nil, client.Callbacks{}, nil)
+	if err := a.Connect(); err != nil {
+		t.Fatalf("connect a: %v", err)
+	}
+	defer a.Disconnect()
+
+	// the relayed stream reaches b while a is still writing it, so the
+	// server did not buffer it to add a checksum
+	pr, pw := io.Pipe()
+	sent := make(chan error, 1)
+	go func() {
+		msg := &message.Message{Metadata: map[string]any{message.MetadataDestination: bID}}
+		sent <- a.SendStream(msg, pr, 11)
+	}()
+	pw.Write([]byte("hello"))
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("relayed stream held back by the server")
+	}
+	pw.Write([]byte(" world"))
+	pw.Close()
+	if err := <-sent; err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if s := <-got; s != "hello world" {
+		t.Fatalf("b got %q", s)
+	}
+
+	// the sender's checksum passes through and is verified by b
+	cliOpts := client.DefaultOptions()
+	cliOpts.Checksums = true
+	c := client.New("127.0.0.1:30354", nil, client.Callbacks{}, &cliOpts)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("connect c: %v", err)
+	}
+	defer c.Disconnect()
+	msg := &message.Message{Metadata: map[string]any{message.MetadataDestination: bID}}
+	if err := c.SendStream(msg, strings.NewReader("hello again"), 11); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	select {
+	case s := <-got:
+		if s != "hello again" {
+			t.Fatalf("b got %q", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("checksummed relay not received")
+	}
+	if n := b.Statistics().ChecksumMismatches(); n != 0 {
+		t.Fatalf("%d checksum mismatches", n)
+	}
+}
+
+func TestChunkedStream(t *testing.T) {
+	results := make(chan string, 2)
+	cb := server.Callbacks{
+		OnStream: func(id string, msg *message.Message, r io.Reader) {
+			if msg.Metadata["mode"] == "partial" {
+				buf := make([]byte, 4)
+				io.ReadFull(r, buf)
+				return // leaving the rest unread cancels the stream
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				results <- "error: " + err.Error()
+				return
+			}
+			results <- string(data)
+		},
+	}
+	opts := server.DefaultOptions()
+	opts.ChunkSize = 4
+	srv := server.New("127.0.0.1:30190", nil, cb, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cliOpts := client.DefaultOptions()
+	cliOpts.ChunkSize = 4
+	cli := client.New("127.0.0.1:30190", nil, client.Callbacks{}, &cliOpts)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+
+	pr, pw := io.Pipe()
+	go func() {
+		for _, part := range []string{"live ", "log ", "output"} {
+			pw.Write([]byte(part))
+			time.Sleep(10 * time.Millisecond)
+		}
+		pw.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cli.SendChunked(ctx, &message.Message{}, pr); err != nil {
+		t.Fatalf("SendChunked: %v", err)
+	}
+	select {
+	case got := <-results:
+		if got != "live log output" {
+			t.Fatalf("unexpected stream %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("stream not received")
+	}
+
+	endless := &repeatReader{b: 'x'}
+	err := cli.SendChunked(ctx, &message.Message{Metadata: map[string]any{"mode": "partial"}}, endless)
+	if !errors.Is(err, message.ErrStreamCanceled) {
+		t.Fatalf("expected receiver cancellation, got %v", err)
+	}
+}
+
+type repeatReader struct{ b byte }
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.b
+	}
+	return len(p), nil
+}
+
+func TestMultiplex(t *testing.T) {
+	const size = 64 * 1024
+	received := make(chan int, 1)
+	var srv *server.Server
+	cb := server.Callbacks{
+		OnMessage: func(id string, msg *message.Message, data []byte) {
+			if msg.SyncRequest {
+				srv.Send(id, &message.Message{SyncResponse: true, ConversationGUID: msg.ConversationGUID}, []byte("pong"))
+				return
+			}
+			received <- len(data)
+		},
+	}
+	opts := server.DefaultOptions()
+	opts.Multiplex = true
+	opts.ChunkSize = 1024
+	srv = server.New("127.0.0.1:30200", nil, cb, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cliOpts := client.DefaultOptions()
+	cliOpts.Multiplex = true
+	cliOpts.ChunkSize = 1024
+	cli := client.New("127.0.0.1:30200", nil, client.Callbacks{}, &cliOpts)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+
+	// The bulk transfer stalls half way until the sync call has completed.
+	gated := &gatedReader{gate: make(chan struct{}), entered: make(chan struct{}), r: io.LimitReader(&repeatReader{b: 'b'}, size/2)}
+	bulk := io.MultiReader(io.LimitReader(&repeatReader{b: 'a'}, size/2), gated)
+	sent := make(chan error, 1)
+	go func() { sent <- cli.SendStream(&message.Message{}, bulk, size) }()
+	defer gated.release()
+	<-gated.entered
+
+	reply := make(chan string, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_, data, err := cli.SendSync(ctx, &message.Message{}, []byte("ping"))
+		if err != nil {
+			data = []byte(err.Error())
+		}
+		reply <- string(data)
+	}()
+	select {
+	case got := <-reply:
+		if got != "pong" {
+			t.Fatalf("unexpected response %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("SendSync blocked behind bulk transfer")
+	}
+	gated.release()
+
+	if err := <-sent; err != nil {
+		t.Fatalf("SendStream: %v", err)
+	}
+	select {
+	case n := <-received:
+		if n != size {
+			t.Fatalf("received %d bytes, want %d", n, size)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("bulk payload not received")
+	}
+}
+
+type gatedReader struct {
+	gate     chan struct{}
+	entered  chan struct{}
+	once     sync.Once
+	released sync.Once
+	r        io.Reader
+}
+
+func (g *gatedReader) release() { g.released.Do(func() { close(g.gate) }) }
+
+func (g *gatedReader) Read(p []byte) (int, error) {
+	g.once.Do(func() { close(g.entered) })
+	<-g.gate
+	return g.r.Read(p)
+}
+
+func TestFileTransfer(t *testing.T) {
+	received := make(chan string, 1)
+	srv := startServer(t, "127.0.0.1:30210", nil, server.Callbacks{})
+	defer srv.Stop()
+	srv.ReceiveFile(t.TempDir(), func(id, name, path string) { received <- path })
+
+	cli := client.New("127.0.0.1:30210", nil, client.Callbacks{}, nil)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+
+	src := filepath.Join(t.TempDir(), "report.bin")
+	want := bytes.Repeat([]byte("watson"), 300000)
+	if err := os.WriteFile(src, want, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cli.SendFile(ctx, src); err != nil {
+		t.Fatalf("SendFile: %v", err)
+	}
+	select {
+	case path := <-received:
+		got, err := os.ReadFile(path)
+		if err != nil || !bytes.Equal(got, want) || filepath.Base(path) != "report.bin" {
+			t.Fatalf("bad file at %s: %v", path, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("file not received")
+	}
+
+	ids := srv.ListClients()
+	if len(ids) != 1 {
+		t.Fatalf("expected one client, got %v", ids)
+	}
+	if err := srv.SendFile(ctx, ids[0], src); !errors.Is(err, message.ErrFileNotAccepted) {
+		t.Fatalf("expected ErrFileNotAccepted, got %v", err)
+	}
+}
+
+// dropProxy forwards connections from listen to target. The first
+// connection stops passing responses once after bytes have gone upstream and
+// is cut as soon as the client has nothing more to send, so the client is
+// left waiting for a reply that never comes. Later connections pass through.
+func dropProxy(t *testing.T, listen, target string, after int64) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatalf("proxy listen: %v", err)
+	}
+	go func() {
+		for first := true; ; first = false {
+			down, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			up, err := net.Dial("tcp", target)
+			if err != nil {
+				down.Close()
+				continue
+			}
+			if !first {
+				go func() { io.Copy(up, down); up.Close() }()
+				go func() { io.Copy(down, up); down.Close() }()
+				continue
+			}
+			var mute atomic.Bool
+			go func() {
+				defer down.Close()
+				defer up.Close()
+				buf := make([]byte, 32*1024)
+				var n int64
+				for {
+					if mute.Load() {
+						down.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+					}
+					k, err := down.Read(buf)
+					if k > 0 {
+						up.Write(buf[:k])
+						n += int64(k)
+						mute.Store(n >= after)
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+			go func() {
+				buf := make([]byte, 32*1024)
+				for {
+					k, err := up.Read(buf)
+					if k > 0 && !mute.Load() {
+						down.Write(buf[:k])
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestFileTransferResume(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		offsets []int64
+	)
+	opts := server.DefaultOptions()
+	opts.ReceiveInterceptors = []server.Interceptor{func(id string, msg *message.Message, p *message.Payload) error {
+		if msg.Metadata[message.MetadataFileAction] == filexfer.ActionData {
+			off, _ := msg.Metadata[message.MetadataFileOffset].(float64)
+			mu.Lock()
+			offsets = append(offsets, int64(off))
+			mu.Unlock()
+		}
+		return nil
+	}}
+	received := make(chan string, 1)
+	srv := server.New("127.0.0.1:30350", nil, server.Callbacks{}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+	srv.ReceiveFile(t.TempDir(), func(id, name, path string) { received <- path })
+	proxy := dropProxy(t, "127.0.0.1:30351", "127.0.0.1:30350", filexfer.BlockSize+filexfer.BlockSize/2)
+	defer proxy.Close()
+
+	src := filepath.Join(t.TempDir(), "archive.bin")
+	want := make([]byte, 3*filexfer.BlockSize+100)
+	rand.Read(want)
+	if err := os.WriteFile(src, want, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cli := client.New("127.0.0.1:30351", nil, client.Callbacks{}, nil)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	start := time.Now()
+	if err := cli.SendFile(ctx, src); !errors.Is(err, client.ErrNotConnected) {
+		t.Fatalf("interrupted SendFile: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("SendFile took %v to notice the drop", d)
+	}
+	cli.Disconnect()
+	mu.Lock()
+	interrupted := len(offsets)
+	mu.Unlock()
+
+	cli = client.New("127.0.0.1:30351", nil, client.Callbacks{}, nil)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	defer cli.Disconnect()
+	if err := cli.SendFile(ctx, src); err != nil {
+		t.Fatalf("resumed SendFile: %v", err)
+	}
+	select {
+	case path := <-received:
+		got, err := os.ReadFile(path)
+		if err != nil || sha256.Sum256(got) != sha256.Sum256(want) {
+			t.Fatalf("file hash differs: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("file not received")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if interrupted == 0 || len(offsets) == interrupted || offsets[interrupted] == 0 {
+		t.Fatalf("transfer did not resume: offsets %v, %d before the drop", offsets, interrupted)
+	}
+}
+
+func TestSyncFailsOnDisconnect(t *testing.T) {
+	requested := make(chan string, 1)
+	srv := startServer(t, "127.0.0.1:30352", nil, server.Callbacks{
+		OnMessage: func(id string, msg *message.Message, data []byte) { requested <- id },
+	})
+	defer srv.Stop()
+
+	cli := client.New("127.0.0.1:30352", nil, client.Callbacks{}, nil)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the server never answers, so only the disconnect ends the request
+	cliErr := make(chan error, 1)
+	go func() {
+		_, _, err := cli.SendSync(ctx, &message.Message{}, []byte("ping"))
+		cliErr <- err
+	}()
+	id := <-requested
+	srvErr := make(chan error, 1)
+	go func() {
+		_, _, err := srv.SendSync(ctx, id, &message.Message{}, []byte("ping"))
+		srvErr <- err
+	}()
+	waitFor(t, func() bool { return srv.Statistics().SentMessages() > 0 })
+	cli.Disconnect()
+
+	if err := <-cliErr; !errors.Is(err, client.ErrNotConnected) {
+		t.Fatalf("client SendSync: %v", err)
+	}
+	if err := <-srvErr; !errors.Is(err, server.ErrNotConnected) {
+		t.Fatalf("server SendSync: %v", err)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	received := make(chan string, 4)
+	srv := startServer(t, "127.0.0.1:30220", nil, server.Callbacks{
+		OnMessage: func(id string, msg *message.Message, data []byte) { received <- string(data) },
+	})
+	defer srv.Stop()
+	streamed := make(chan string, 4)
+	streamSrv := startServer(t, "127.0.0.1:30221", nil, server.Callbacks{
+		OnStream: func(id string, msg *message.Message, r io.Reader) {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				streamed <- err.Error()
+				return
+			}
+			streamed <- string(data)
+		},
+	})
+	defer streamSrv.Stop()
+
+	opts := client.DefaultOptions()
+	opts.Checksums = true
+	for _, addr := range []string{"127.0.0.1:30220", "127.0.0.1:30221"} {
+		cli := client.New(addr, nil, client.Callbacks{}, &opts)
+		if err := cli.Connect(); err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		defer cli.Disconnect()
+		if err := cli.SendStream(&message.Message{}, bytes.NewReader([]byte("intact")), 6); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	for _, ch := range []chan string{received, streamed} {
+		select {
+		case got := <-ch:
+			if got != "intact" {
+				t.Fatalf("unexpected payload %q", got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("checksummed message not received")
+		}
+	}
+
+	// a reused message must not keep the checksum of its earlier payload
+	reused := &message.Message{}
+	cli := client.New("127.0.0.1:30221", nil, client.Callbacks{}, &opts)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+	for _, payload := range []string{"first", "second"} {
+		if err := cli.SendStream(reused, strings.NewReader(payload), int64(len(payload))); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		select {
+		case got := <-streamed:
+			if got != payload {
+				t.Fatalf("reused message: got %q, want %q", got, payload)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("reused message not received")
+		}
+	}
+
+	// frames whose payload no longer matches the header checksum
+	corrupt := func(addr string) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		hdr, _ := message.BuildHeader(&message.Message{ContentLength: 6, Checksum: message.Checksum([]byte("intact"))})
+		conn.Write(hdr)
+		conn.Write([]byte("broken"))
+		time.Sleep(100 * time.Millisecond)
+	}
+	corrupt("127.0.0.1:30220")
+	select {
+	case got := <-received:
+		t.Fatalf("corrupt payload delivered: %q", got)
+	default:
+	}
+	if n := srv.Statistics().ChecksumMismatches(); n != 1 {
+		t.Fatalf("expected 1 mismatch, got %d", n)
+	}
+
+	corrupt("127.0.0.1:30221")
+	select {
+	case got := <-streamed:
+		if got != message.ErrChecksumMismatch.Error() {
+			t.Fatalf("expected checksum error, got %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("corrupt stream not surfaced")
+	}
+	if n := streamSrv.Statistics().ChecksumMismatches(); n != 1 {
+		t.Fatalf("expected 1 stream mismatch, got %d", n)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	addr := "unix://" + filepath.Join(t.TempDir(), "watson.sock")
+	received := make(chan string, 1)
+	opts := server.DefaultOptions()
+	opts.UnixSocketMode = 0o600
+	opts.BlockedIPs = []string{"0.0.0.0/0"} // IP lists do not apply to unix sockets
+	srv := server.New(addr, nil, server.Callbacks{
+		OnMessage: func(id string, msg *message.Message, data []byte) { received <- id },
+	}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	clients := make([]*client.Client, 2)
+	for i := range clients {
+		clients[i] = client.New(addr, nil, client.Callbacks{}, nil)
+		if err := clients[i].Connect(); err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		defer clients[i].Disconnect()
+	}
+	if ids := srv.ListClients(); len(ids) != 2 || ids[0] == ids[1] {
+		t.Fatalf("expected two distinct client ids, got %v", ids)
+	}
+	if err := clients[0].Send(&message.Message{}, []byte("hi")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	var id string
+	select {
+	case id = <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("message not received")
+	}
+	info, ok := srv.ClientInfo(id)
+	if !ok {
+		t.Fatalf("no info for %s", id)
+	}
+	if runtime.GOOS == "linux" {
+		if info.Credentials == nil || info.Credentials.UID != uint32(os.Getuid()) || info.Credentials.PID != os.Getpid() {
+			t.Fatalf("unexpected peer credentials %+v", info.Credentials)
+		}
+	}
+}
+
+func TestUnixSocketUIDPolicy(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only read on linux")
+	}
+	addr := "unix://" + filepath.Join(t.TempDir(), "watson.sock")
+	opts := server.DefaultOptions()
+	opts.PermittedUIDs = []uint32{uint32(os.Getuid()) + 1}
+	srv := server.New(addr, nil, server.Callbacks{}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cli := client.New(addr, nil, client.Callbacks{}, nil)
+	if err := cli.Connect(); err == nil {
+		cli.Disconnect()
+		t.Fatalf("client with unlisted uid connected")
+	}
+}
+
+// pipeListener hands out the server ends of net.Pipe connections.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+func (l *pipeListener) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	srv, cli := net.Pipe()
+	select {
+	case l.conns <- srv:
+		return cli, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestServeCustomTransport(t *testing.T) {
+	ln := newPipeListener()
+	received := make(chan string, 2)
+	var srv *server.Server
+	srv = server.New("", nil, server.Callbacks{
+		OnMessage: func(id string, msg *message.Message, data []byte) {
+			received <- id
+			srv.Send(id, &message.Message{}, append([]byte("echo "), data...))
+		},
+	}, nil)
+	if err := srv.Serve(ln); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	defer srv.Stop()
+
+	replies := make(chan string, 2)
+	opts := client.DefaultOptions()
+	opts.Dialer = ln.Dial
+	for i := 0; i < 2; i++ {
+		cli := client.New("in-memory", nil, client.Callbacks{
+			OnMessage: func(msg *message.Message, data []byte) { replies <- string(data) },
+		}, &opts)
+		if err := cli.Connect(); err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		defer cli.Disconnect()
+		if err := cli.Send(&message.Message{}, []byte("hi")); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	ids := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-received:
+			ids[id] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("message not received")
+		}
+		select {
+		case got := <-replies:
+			if got != "echo hi" {
+				t.Fatalf("unexpected reply %q", got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("reply not received")
+		}
+	}
+	if len(ids) != 2 {
+		t.Fatalf("pipe clients share an id: %v", ids)
+	}
+
+	// a pre-bound TCP listener behaves like Start
+	tcp, err := net.Listen("tcp", "127.0.0.1:30230")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound := server.New("", nil, server.Callbacks{}, nil)
+	if err := bound.Serve(tcp); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	defer bound.Stop()
+	cli := client.New("127.0.0.1:30230", nil, client.Callbacks{}, nil)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect to pre-bound listener: %v", err)
+	}
+	cli.Disconnect()
+}
+
+func TestWebSocketBridge(t *testing.T) {
+	ln := websocket.NewListener()
+	hs := &http.Server{Addr: "127.0.0.1:30240", Handler: ln}
+	go hs.ListenAndServe()
+	defer hs.Close()
+
+	received := make(chan string, 1)
+	srvOpts := server.DefaultOptions()
+	srvOpts.PresharedKey = "0000000000000000"
+	var srv *server.Server
+	srv = server.New("", nil, server.Callbacks{
+		OnMessage: func(id string, msg *message.Message, data []byte) {
+			if msg.SyncRequest {
+				srv.Send(id, &message.Message{SyncResponse: true, ConversationGUID: msg.ConversationGUID}, []byte("pong"))
+				return
+			}
+			received <- id
+		},
+	}, &srvOpts)
+	if err := srv.Serve(ln); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	defer srv.Stop()
+
+	opts := client.DefaultOptions()
+	opts.PresharedKey = "0000000000000000"
+	opts.Dialer = func(ctx context.Context, addr string) (net.Conn, error) {
+		return websocket.Dial(ctx, addr, nil)
+	}
+	cli := client.New("ws://127.0.0.1:30240/watson", nil, client.Callbacks{}, &opts)
+	var err error
+	for i := 0; i < 20; i++ { // wait for the HTTP server to listen
+		if err = cli.Connect(); err == nil {
+			break
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+
+	if err := cli.Send(&message.Message{}, []byte("hello")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	select {
+	case id := <-received:
+		if !strings.HasPrefix(id, "127.0.0.1:") {
+			t.Fatalf("expected the browser's address as id, got %s", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("message not received")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, data, err := cli.SendSync(ctx, &message.Message{}, []byte("ping")); err != nil || string(data) != "pong" {
+		t.Fatalf("SendSync: %q %v", data, err)
+	}
+	if srv.Statistics().ReceivedMessages() < 2 {
+		t.Fatalf("websocket traffic not counted")
+	}
+}
+
+// proxyDialer returns a dialer that announces src in a PROXY v1 header, as a
+// load balancer would.
+func proxyDialer(src string) func(ctx context.Context, addr string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		host, port, _ := net.SplitHostPort(src)
+		fmt.Fprintf(conn, "PROXY TCP4 %s 127.0.0.1 %s 9000\r\n", host, port)
+		return conn, nil
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	received := make(chan string, 2)
+	opts := server.DefaultOptions()
+	opts.TrustedProxies = []string{"127.0.0.0/8"}
+	opts.BlockedIPs = []string{"198.51.100.0/24"}
+	srv := server.New("127.0.0.1:30250", nil, server.Callbacks{
+		OnMessage: func(id string, msg *message.Message, data []byte) { received <- id },
+	}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cliOpts := client.DefaultOptions()
+	cliOpts.Dialer = proxyDialer("203.0.113.7:5555")
+	cli := client.New("127.0.0.1:30250", nil, client.Callbacks{}, &cliOpts)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+	cli.Send(&message.Message{}, []byte("hi"))
+	select {
+	case id := <-received:
+		if id != "203.0.113.7:5555" {
+			t.Fatalf("expected the proxied client address as id, got %s", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("message not received")
+	}
+
+	// filtering uses the proxied address
+	cliOpts.Dialer = proxyDialer("198.51.100.20:5555")
+	blocked := client.New("127.0.0.1:30250", nil, client.Callbacks{}, &cliOpts)
+	if err := blocked.Connect(); err == nil {
+		blocked.Disconnect()
+		t.Fatalf("blocked client address admitted through proxy")
+	}
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	received := make(chan string, 1)
+	disconnected := make(chan message.DisconnectReason, 1)
+	opts := server.DefaultOptions()
+	opts.TrustedProxies = []string{"10.0.0.0/8"}
+	opts.PresharedKey = "0000000000000000"
+	srv := server.New("127.0.0.1:30251", nil, server.Callbacks{
+		OnMessage:    func(id string, msg *message.Message, data []byte) { received <- id },
+		OnDisconnect: func(id string, reason message.DisconnectReason) { disconnected <- reason },
+	}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cliOpts := client.DefaultOptions()
+	cliOpts.PresharedKey = "0000000000000000"
+	cliOpts.Dialer = proxyDialer("203.0.113.7:5555")
+	cli := client.New("127.0.0.1:30251", nil, client.Callbacks{}, &cliOpts)
+	if err := cli.Connect(); err == nil {
+		cli.Send(&message.Message{}, []byte("spoofed"))
+		cli.Disconnect()
+	}
+	select {
+	case id := <-received:
+		t.Fatalf("message from untrusted proxy header delivered as %s", id)
+	case <-time.After(200 * time.Millisecond):
+	}
+	select {
+	case reason := <-disconnected:
+		if reason != message.DisconnectProtocolError {
+			t.Fatalf("unexpected disconnect reason %v", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("untrusted proxy header not rejected")
+	}
+}
+
+// TestProxyProtocolDirectClient connects a client that sends nothing until
+// the server registers it, directly rather than through a trusted proxy.
+func TestProxyProtocolDirectClient(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.TrustedProxies = []string{"10.0.0.0/8"}
+	var srv *server.Server
+	srv = server.New("127.0.0.1:30252", nil, server.Callbacks{
+		OnMessage: func(id string, msg *message.Message, data []byte) {
+			srv.Send(id, &message.Message{SyncResponse: true, ConversationGUID: msg.ConversationGUID}, data)
+		},
+	}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cliOpts := client.DefaultOptions()
+	cliOpts.ConnectTimeout = time.Second
+	cli := client.New("127.0.0.1:30252", nil, client.Callbacks{}, &cliOpts)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, data, err := cli.SendSync(ctx, &message.Message{}, []byte("direct")); err != nil || string(data) != "direct" {
+		t.Fatalf("SendSync: %q %v", data, err)
+	}
+}
+
+func TestRuntimeIPLists(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.DisconnectBlocked = true
+	srv := server.New("127.0.0.1:30260", nil, server.Callbacks{}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	disconnected := make(chan struct{}, 1)
+	cli := client.New("127.0.0.1:30260", nil, client.Callbacks{
+		OnDisconnect: func(message.DisconnectReason) { disconnected <- struct{}{} },
+	}, nil)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+
+	if err := srv.AddBlocked("127.0.0.0/8", "bogus"); err == nil {
+		t.Fatalf("invalid entry accepted")
+	}
+	if err := srv.AddBlocked("127.0.0.1"); err != nil {
+		t.Fatalf("AddBlocked: %v", err)
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("newly blocked client not disconnected")
+	}
+	again := client.New("127.0.0.1:30260", nil, client.Callbacks{}, nil)
+	if err := again.Connect(); err == nil {
+		again.Disconnect()
+		t.Fatalf("blocked client connected")
+	}
+
+	srv.RemoveBlocked("127.0.0.1")
+	if err := again.Connect(); err != nil {
+		t.Fatalf("connect after unblock: %v", err)
+	}
+	defer again.Disconnect()
+	if err := srv.ReplaceLists([]string{"10.0.0.0/8"}, nil); err != nil {
+		t.Fatalf("ReplaceLists: %v", err)
+	}
+	waitFor(t, func() bool { return len(srv.ListClients()) == 0 })
+	if got := srv.PermittedIPs(); len(got) != 1 || got[0] != "10.0.0.0/8" {
+		t.Fatalf("unexpected permit list %v", got)
+	}
+}
+
+func TestBlockStalledClients(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.DisconnectBlocked = true
+	srv := server.New("127.0.0.1:30355", nil, server.Callbacks{}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	// clients that never read, with a large send stuck on each connection,
+	// so every removal notice waits out its write timeout
+	const stalled = 3
+	for range stalled {
+		conn, err := net.Dial("tcp", "127.0.0.1:30355")
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+	}
+	waitFor(t, func() bool { return len(srv.ListClients()) == stalled })
+	big := make([]byte, 64<<20)
+	for _, id := range srv.ListClients() {
+		go srv.Send(id, &message.Message{}, big)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	if err := srv.AddBlocked("127.0.0.1"); err != nil {
+		t.Fatalf("AddBlocked: %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("AddBlocked took %v for %d stalled clients", d, stalled)
+	}
+	waitFor(t, func() bool { return len(srv.ListClients()) == 0 })
+}
+
+func TestIPListFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ips.conf")
+	os.WriteFile(path, []byte("# local clients\nblock 127.0.0.1\n"), 0o644)
+	opts := server.DefaultOptions()
+	opts.IPListFile = path
+	opts.IPListReloadInterval = 20 * time.Millisecond
+	srv := server.New("127.0.0.1:30261", nil, server.Callbacks{}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cli := client.New("127.0.0.1:30261", nil, client.Callbacks{}, nil)
+	if err := cli.Connect(); err == nil {
+		cli.Disconnect()
+		t.Fatalf("client blocked by file connected")
+	}
+	os.WriteFile(path, []byte("permit 127.0.0.0/8\n"), 0o644)
+	waitFor(t, func() bool { return len(srv.BlockedIPs()) == 0 })
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect after reload: %v", err)
+	}
+	cli.Disconnect()
+
+	bad := server.New("127.0.0.1:30262", nil, server.Callbacks{}, &server.Options{PermittedIPs: []string{"not-an-ip"}})
+	if err := bad.Start(); err == nil {
+		bad.Stop()
+		t.Fatalf("invalid PermittedIPs accepted")
+	}
+}
+
+func TestBans(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.PresharedKey = "0000000000000000"
+	opts.Ban = server.BanPolicy{MaxFailures: 2, Duration: time.Minute}
+	bans := make(chan server.BanEntry, 4)
+	srv := server.New("127.0.0.1:30270", nil, server.Callbacks{
+		OnBan: func(b server.BanEntry) { bans <- b },
+	}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	bad := client.DefaultOptions()
+	bad.PresharedKey = "1111111111111111"
+	for i := 0; i < 2; i++ {
+		cli := client.New("127.0.0.1:30270", nil, client.Callbacks{}, &bad)
+		if err := cli.Connect(); err == nil {
+			cli.Disconnect()
+			t.Fatalf("connected with the wrong key")
+		}
+	}
+	var first server.BanEntry
+	select {
+	case first = <-bans:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no ban after repeated failures")
+	}
+	if first.IP != "127.0.0.1" || first.Count != 1 || first.Until.Sub(first.Since) != time.Minute {
+		t.Fatalf("unexpected ban %+v", first)
+	}
+	if got := srv.Bans(); len(got) != 1 || got[0].IP != "127.0.0.1" {
+		t.Fatalf("unexpected ban table %+v", got)
+	}
+
+	good := client.DefaultOptions()
+	good.PresharedKey = opts.PresharedKey
+	cli := client.New("127.0.0.1:30270", nil, client.Callbacks{}, &good)
+	if err := cli.Connect(); err == nil {
+		cli.Disconnect()
+		t.Fatalf("banned address connected")
+	}
+
+	// a repeat ban escalates
+	if err := srv.Ban("127.0.0.1", 0, "manual"); err != nil {
+		t.Fatalf("Ban: %v", err)
+	}
+	if b := <-bans; b.Count != 2 || b.Until.Sub(b.Since) != 2*time.Minute || b.Reason != "manual" {
+		t.Fatalf("unexpected escalated ban %+v", b)
+	}
+
+	if ok, err := srv.Unban("127.0.0.1"); !ok || err != nil {
+		t.Fatalf("Unban: %v %v", ok, err)
+	}
+	disconnected := make(chan struct{}, 1)
+	cli = client.New("127.0.0.1:30270", nil, client.Callbacks{
+		OnDisconnect: func(message.DisconnectReason) { disconnected <- struct{}{} },
+	}, &good)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect after unban: %v", err)
+	}
+	defer cli.Disconnect()
+	srv.Ban("127.0.0.1", time.Minute, "manual")
+	select {
+	case <-disconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("banned client not disconnected")
+	}
+	if _, err := srv.Unban("not-an-ip"); err == nil {
+		t.Fatalf("invalid address accepted")
+	}
+}
+
+func TestAdmissionHook(t *testing.T) {
+	tlsConf, err := newTLSConfig()
+	if err != nil {
+		t.Fatalf("tls config: %v", err)
+	}
+	opts := server.DefaultOptions()
+	opts.PresharedKey = "0000000000000000"
+	opts.MaxConnections = 1
+	opts.NotifyRejected = true
+	var mu sync.Mutex
+	refuse := true
+	var sawTLS bool
+	rejected := make(chan string, 4)
+	srv := server.New("127.0.0.1:30280", tlsConf, server.Callbacks{
+		OnAccept: func(addr net.Addr, state *tls.ConnectionState) (bool, string) {
+			mu.Lock()
+			defer mu.Unlock()
+			sawTLS = state != nil && state.HandshakeComplete
+			if refuse {
+				return false, "maintenance"
+			}
+			return true, ""
+		},
+		OnRejected: func(addr net.Addr, reason string) { rejected <- reason },
+	}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cliOpts := client.DefaultOptions()
+	cliOpts.PresharedKey = opts.PresharedKey
+	connect := func() (*client.Client, error) {
+		cli := client.New("127.0.0.1:30280", &tls.Config{InsecureSkipVerify: true}, client.Callbacks{}, &cliOpts)
+		return cli, cli.Connect()
+	}
+	expectRejected := func(want string) {
+		t.Helper()
+		_, err := connect()
+		var rerr *client.RejectedError
+		if !errors.As(err, &rerr) || rerr.Reason != want {
+			t.Fatalf("expected rejection %q, got %v", want, err)
+		}
+		select {
+		case got := <-rejected:
+			if got != want {
+				t.Fatalf("OnRejected reason %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("OnRejected not called")
+		}
+	}
+
+	expectRejected("maintenance")
+	mu.Lock()
+	refuse = false
+	if !sawTLS {
+		t.Errorf("OnAccept called before the TLS handshake")
+	}
+	mu.Unlock()
+
+	cli, err := connect()
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+	waitFor(t, func() bool { return len(srv.ListClients()) == 1 })
+	expectRejected("too many connections")
+}
+
+func TestErrors(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := server.New("127.0.0.1:30290", nil, server.Callbacks{
+		OnError: func(id string, err error) { errs <- err },
+	}, nil)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+	if err := srv.Send("nobody", &message.Message{}, nil); !errors.Is(err, server.ErrUnknownClient) {
+		t.Fatalf("expected ErrUnknownClient, got %v", err)
+	}
+	cli := client.New("127.0.0.1:30290", nil, client.Callbacks{}, nil)
+	if err := cli.Send(&message.Message{}, nil); !errors.Is(err, client.ErrNotConnected) {
+		t.Fatalf("expected ErrNotConnected, got %v", err)
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:30290")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "{\"len\":-1}\r\n\r\n")
+	var perr *message.ProtocolError
+	select {
+	case err := <-errs:
+		if !errors.As(err, &perr) || string(perr.Header) != "{\"len\":-1}" {
+			t.Fatalf("expected protocol error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("OnError not called")
+	}
+
+	// a server that registers the client and then sends garbage
+	ln, err := net.Listen("tcp", "127.0.0.1:30291")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		hdr, _ := message.BuildHeader(&message.Message{Status: message.StatusRegisterClient})
+		c.Write(hdr)
+		io.WriteString(c, "garbage\r\n\r\n")
+		io.Copy(io.Discard, c)
+	}()
+	cliErrs := make(chan error, 1)
+	cli = client.New("127.0.0.1:30291", nil, client.Callbacks{
+		OnError: func(err error) { cliErrs <- err },
+	}, nil)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+	select {
+	case err := <-cliErrs:
+		if !errors.As(err, &perr) || string(perr.Header) != "garbage" {
+			t.Fatalf("expected protocol error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("client OnError not called")
+	}
+}
+
+func TestDisconnectReasons(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.PresharedKey = "0000000000000000"
+	opts.IdleTimeout = 300 * time.Millisecond
+	opts.CheckInterval = 50 * time.Millisecond
+	srvReasons := make(chan message.DisconnectReason, 4)
+	srv := server.New("127.0.0.1:30300", nil, server.Callbacks{
+		OnDisconnect: func(id string, reason message.DisconnectReason) { srvReasons <- reason },
+	}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	expect := func(ch chan message.DisconnectReason, want message.DisconnectReason) {
+		t.Helper()
+		select {
+		case got := <-ch:
+			if got != want {
+				t.Fatalf("got reason %s, want %s", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no disconnect, want %s", want)
+		}
+	}
+	connect := func(psk string) (*client.Client, chan message.DisconnectReason, error) {
+		reasons := make(chan message.DisconnectReason, 1)
+		cliOpts := client.DefaultOptions()
+		cliOpts.PresharedKey = psk
+		cli := client.New("127.0.0.1:30300", nil, client.Callbacks{
+			OnDisconnect: func(reason message.DisconnectReason) { reasons <- reason },
+		}, &cliOpts)
+		return cli, reasons, cli.Connect()
+	}
+
+	if _, _, err := connect("1111111111111111"); err == nil {
+		t.Fatalf("connected with the wrong key")
+	}
+	expect(srvReasons, message.DisconnectAuthFailure)
+
+	_, cliReasons, err := connect(opts.PresharedKey)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	expect(srvReasons, message.DisconnectIdleTimeout)
+	expect(cliReasons, message.DisconnectIdleTimeout)
+
+	cli, cliReasons, err := connect(opts.PresharedKey)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	srv.Ban("127.0.0.1", time.Minute, "test")
+	expect(srvReasons, message.DisconnectRemoved)
+	expect(cliReasons, message.DisconnectRemoved)
+	srv.Unban("127.0.0.1")
+	if cli.Statistics().Disconnects(message.DisconnectRemoved) != 1 {
+		t.Fatalf("client did not count the removal")
+	}
+
+	_, cliReasons, err = connect(opts.PresharedKey)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	srv.Stop()
+	expect(srvReasons, message.DisconnectShutdown)
+	expect(cliReasons, message.DisconnectShutdown)
+	st := srv.Statistics()
+	for _, r := range []message.DisconnectReason{message.DisconnectAuthFailure, message.DisconnectIdleTimeout, message.DisconnectRemoved, message.DisconnectShutdown} {
+		if st.Disconnects(r) != 1 {
+			t.Errorf("server counted %d disconnects for %s", st.Disconnects(r), r)
+		}
+	}
+}
+
+func TestIdleTimeoutNotice(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.IdleTimeout = 300 * time.Millisecond
+	opts.CheckInterval = 50 * time.Millisecond
+	connected := make(chan string, 2)
+	disconnected := make(chan string, 2)
+	srv := server.New("127.0.0.1:30310", nil, server.Callbacks{
+		OnConnect: func(id string, _ net.Conn) { connected <- id },
+		OnDisconnect: func(id string, reason message.DisconnectReason) {
+			if reason == message.DisconnectIdleTimeout {
+				disconnected <- id
+			}
+		},
+	}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+	if err := srv.SetIdleTimeout("nobody", time.Second); !errors.Is(err, server.ErrUnknownClient) {
+		t.Fatalf("expected ErrUnknownClient, got %v", err)
+	}
+
+	patient := client.New("127.0.0.1:30310", nil, client.Callbacks{}, nil)
+	if err := patient.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer patient.Disconnect()
+	patientID := <-connected
+	if err := srv.SetIdleTimeout(patientID, -1); err != nil {
+		t.Fatalf("SetIdleTimeout: %v", err)
+	}
+	idle := client.New("127.0.0.1:30310", nil, client.Callbacks{}, nil)
+	if err := idle.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer idle.Disconnect()
+	idleID := <-connected
+	select {
+	case id := <-disconnected:
+		if id != idleID {
+			t.Fatalf("client with timeout override was disconnected")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("idle client not disconnected")
+	}
+	if clients := srv.ListClients(); len(clients) != 1 || clients[0] != patientID {
+		t.Fatalf("unexpected clients %v", clients)
+	}
+
+	// a client closing its own idle connection tells the server why
+	srvOpts := server.DefaultOptions()
+	srvOpts.IdleTimeout = 0
+	reasons := make(chan message.DisconnectReason, 1)
+	srv2 := server.New("127.0.0.1:30311", nil, server.Callbacks{
+		OnMessage:    func(id string, msg *message.Message, data []byte) { t.Errorf("timeout notice delivered as a message") },
+		OnDisconnect: func(id string, reason message.DisconnectReason) { reasons <- reason },
+	}, &srvOpts)
+	if err := srv2.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv2.Stop()
+	cliOpts := client.DefaultOptions()
+	cliOpts.IdleTimeout = 150 * time.Millisecond
+	cliOpts.EvaluationInterval = 50 * time.Millisecond
+	cli := client.New("127.0.0.1:30311", nil, client.Callbacks{}, &cliOpts)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+	select {
+	case r := <-reasons:
+		if r != message.DisconnectIdleTimeout {
+			t.Fatalf("server saw %s", r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("client did not close its idle connection")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	received := make(chan string, 4)
+	start := func(addr string) *server.Server {
+		srv := server.New(addr, nil, server.Callbacks{
+			OnMessage: func(id string, msg *message.Message, data []byte) { received <- addr },
+		}, nil)
+		if err := srv.Start(); err != nil {
+			t.Fatalf("server start: %v", err)
+		}
+		return srv
+	}
+	first := start("127.0.0.1:30320")
+	defer first.Stop()
+	second := start("127.0.0.1:30321")
+	defer second.Stop()
+
+	opts := client.DefaultFailoverOptions()
+	opts.ConnectTimeout = time.Second
+	opts.RetryInterval = 50 * time.Millisecond
+	connects := make(chan struct{}, 4)
+	f := client.NewFailover([]string{"127.0.0.1:30329", "127.0.0.1:30320", "127.0.0.1:30321"}, nil, client.Callbacks{
+		OnConnect: func() { connects <- struct{}{} },
+	}, &opts)
+	if err := f.Send(&message.Message{}, nil); !errors.Is(err, client.ErrNotConnected) {
+		t.Fatalf("expected ErrNotConnected, got %v", err)
+	}
+	if err := f.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer f.Disconnect()
+	<-connects
+	if got := f.Active(); got != "127.0.0.1:30320" {
+		t.Fatalf("active endpoint %q, want the first live one", got)
+	}
+	eps := f.Endpoints()
+	if eps[0].Failures != 1 || eps[0].CooldownUntil.IsZero() || !eps[1].Active || eps[1].Latency == 0 {
+		t.Fatalf("unexpected endpoint health %+v", eps)
+	}
+
+	first.Stop()
+	select {
+	case <-connects:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not fail over")
+	}
+	if got := f.Active(); got != "127.0.0.1:30321" {
+		t.Fatalf("failed over to %q", got)
+	}
+	if err := f.Send(&message.Message{}, []byte("hi")); err != nil {
+		t.Fatalf("send after failover: %v", err)
+	}
+	if got := <-received; got != "127.0.0.1:30321" {
+		t.Fatalf("message reached %s", got)
+	}
+
+	f.Disconnect()
+	if f.Active() != "" || !errors.Is(f.Connect(), client.ErrClosed) {
+		t.Fatalf("failover still usable after Disconnect")
+	}
+
+	resolved := client.DefaultFailoverOptions()
+	resolved.Strategy = client.LeastLatency
+	resolved.Resolver = func(ctx context.Context) ([]string, error) {
+		return []string{"127.0.0.1:30321"}, nil
+	}
+	r := client.NewFailover(nil, nil, client.Callbacks{}, &resolved)
+	if err := r.Connect(); err != nil {
+		t.Fatalf("connect via resolver: %v", err)
+	}
+	defer r.Disconnect()
+	if got := r.Active(); got != "127.0.0.1:30321" {
+		t.Fatalf("resolved endpoint %q", got)
+	}
+}
+
+func TestPool(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.CheckInterval = 50 * time.Millisecond
+	var mu sync.Mutex
+	senders := make(map[string]int)
+	var srv *server.Server
+	srv = server.New("127.0.0.1:30330", nil, server.Callbacks{
+		OnMessage: func(id string, msg *message.Message, data []byte) {
+			mu.Lock()
+			senders[id]++
+			mu.Unlock()
+			if msg.SyncRequest {
+				srv.Send(id, &message.Message{SyncResponse: true, ConversationGUID: msg.ConversationGUID}, data)
+			}
+		},
+	}, &opts)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	poolOpts := client.DefaultPoolOptions()
+	poolOpts.Size = 3
+	poolOpts.RetryInterval = 50 * time.Millisecond
+	pool := client.NewPool("127.0.0.1:30330", nil, client.Callbacks{}, &poolOpts)
+	if err := pool.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer pool.Disconnect()
+	waitFor(t, func() bool { return len(srv.ListClients()) == 3 })
+	if err := pool.Connect(); err == nil {
+		t.Fatalf("second Connect succeeded")
+	}
+	if n := len(srv.ListClients()); n != 3 {
+		t.Fatalf("second Connect opened connections: %d clients", n)
+	}
+
+	for i := 0; i < 6; i++ {
+		if err := pool.Send(&message.Message{}, []byte("x")); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, data, err := pool.SendSync(ctx, &message.Message{}, []byte("ping")); err != nil || string(data) != "ping" {
+		t.Fatalf("sync: %q %v", data, err)
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		total := 0
+		for _, n := range senders {
+			total += n
+		}
+		return total == 7
+	})
+	mu.Lock()
+	for id, n := range senders {
+		if n < 2 {
+			t.Errorf("connection %s carried %d messages; round-robin should spread them", id, n)
+		}
+	}
+	mu.Unlock()
+
+	// a connection the server drops is replaced
+	victim := srv.ListClients()[0]
+	srv.SetIdleTimeout(victim, time.Millisecond)
+	waitFor(t, func() bool {
+		clients := srv.ListClients()
+		return len(clients) == 3 && !slices.Contains(clients, victim)
+	})
+	waitFor(t, func() bool { return pool.Connected() == 3 })
+	st := pool.Statistics()
+	if st.SentMessages() < 7 || st.Disconnects(message.DisconnectIdleTimeout) != 1 {
+		t.Fatalf("aggregate statistics: %d sent, %d idle disconnects", st.SentMessages(), st.Disconnects(message.DisconnectIdleTimeout))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+
+	"github.com/WasimAhmad/watsontcp-go/bench"
+)
+
+// runBench runs a load test and prints the report. Without -addr the
+// server runs in-process, which makes it easy to compare two builds of the
+// library on one machine. Interrupting the run reports what was measured so
+// far.
+func runBench(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var f commonFlags
+	fs := newFlagSet("bench", "", stderr)
+	f.register(fs, false)
+	cfg := bench.DefaultConfig()
+	fs.IntVar(&cfg.Clients, "clients", cfg.Clients, "number of connections sending in parallel")
+	fs.IntVar(&cfg.Size, "size", cfg.Size, "payload size in `bytes`")
+	fs.Float64Var(&cfg.SyncRatio, "sync", cfg.SyncRatio, "`fraction` of messages sent as sync requests, 0 to 1")
+	fs.DurationVar(&cfg.Duration, "duration", cfg.Duration, "how long to send for")
+	fs.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "connect and sync request `timeout`")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	remote := false
+	fs.Visit(func(fl *flag.Flag) { remote = remote || fl.Name == "addr" })
+	tlsConf, err := f.clientTLS()
+	if err != nil {
+		return err
+	}
+	if remote {
+		cfg.Addr = f.addr
+		cfg.TLSConfig = tlsConf
+	} else if tlsConf != nil {
+		return errors.New("TLS needs a remote server; set -addr")
+	}
+	cfg.Options.ConnectTimeout = cfg.Timeout
+	cfg.Options.PresharedKey = f.psk
+	cfg.Options.Logger = f.logger(stderr)
+	cfg.Options.DebugMessages = f.debug
+
+	ctx, stop := interrupted()
+	defer stop()
+	res, err := bench.Run(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if f.json {
+		return res.WriteJSON(stdout)
+	}
+	return res.WriteText(stdout)
+}
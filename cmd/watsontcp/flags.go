@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultAddr = "127.0.0.1:9000"
+
+// commonFlags are shared by every subcommand.
+type commonFlags struct {
+	addr  string
+	psk   string
+	json  bool
+	debug bool
+
+	tls        bool
+	cert       string
+	key        string
+	ca         string
+	insecure   bool
+	serverName string
+}
+
+// register adds the shared flags to fs. Server-only and client-only TLS
+// flags are added according to server.
+func (f *commonFlags) register(fs *flag.FlagSet, server bool) {
+	fs.StringVar(&f.addr, "addr", defaultAddr, "`address` to listen on or connect to; unix:///path for a Unix socket")
+	fs.StringVar(&f.psk, "psk", "", "preshared `key` for authentication")
+	fs.BoolVar(&f.json, "json", false, "print one JSON object per line")
+	fs.BoolVar(&f.debug, "debug", false, "log protocol debug messages to stderr")
+	fs.BoolVar(&f.tls, "tls", false, "use TLS")
+	if server {
+		fs.StringVar(&f.cert, "cert", "", "certificate `file` (PEM); implies -tls")
+		fs.StringVar(&f.key, "key", "", "private key `file` (PEM)")
+		fs.StringVar(&f.ca, "ca", "", "require client certificates signed by the CAs in `file` (PEM)")
+		return
+	}
+	fs.StringVar(&f.cert, "cert", "", "client certificate `file` (PEM); implies -tls")
+	fs.StringVar(&f.key, "key", "", "client private key `file` (PEM)")
+	fs.StringVar(&f.ca, "ca", "", "trust the CAs in `file` (PEM) instead of the system roots; implies -tls")
+	fs.BoolVar(&f.insecure, "insecure", false, "skip verification of the server certificate")
+	fs.StringVar(&f.serverName, "server-name", "", "expected `name` in the server certificate; defaults to the host in -addr")
+}
+
+// logger returns a Logger for client or server options when -debug is set.
+func (f *commonFlags) logger(stderr io.Writer) func(format string, args ...any) {
+	if !f.debug {
+		return nil
+	}
+	return func(format string, args ...any) {
+		fmt.Fprintf(stderr, format+"\n", args...)
+	}
+}
+
+// serverTLS returns the server TLS configuration, or nil without TLS.
+func (f *commonFlags) serverTLS() (*tls.Config, error) {
+	if !f.tls && f.cert == "" {
+		return nil, nil
+	}
+	if f.cert == "" || f.key == "" {
+		return nil, errors.New("TLS needs -cert and -key")
+	}
+	cert, err := tls.LoadX509KeyPair(f.cert, f.key)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if f.ca != "" {
+		pool, err := loadCAs(f.ca)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// clientTLS returns the client TLS configuration, or nil without TLS.
+func (f *commonFlags) clientTLS() (*tls.Config, error) {
+	if !f.tls && f.cert == "" && f.ca == "" {
+		return nil, nil
+	}
+	conf := &tls.Config{
+		InsecureSkipVerify: f.insecure,
+		ServerName:         f.serverName,
+	}
+	if conf.ServerName == "" {
+		if host, _, err := net.SplitHostPort(f.addr); err == nil {
+			conf.ServerName = host
+		}
+	}
+	if f.ca != "" {
+		pool, err := loadCAs(f.ca)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	if f.cert != "" || f.key != "" {
+		cert, err := tls.LoadX509KeyPair(f.cert, f.key)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+func loadCAs(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return pool, nil
+}
+
+// metadataFlag collects repeated -md key=value flags. Values that parse as
+// JSON keep their type, so -md n=5 sends a number and -md s=five a string.
+type metadataFlag map[string]any
+
+func (m metadataFlag) String() string {
+	if len(m) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(map[string]any(m))
+	return string(b)
+}
+
+func (m metadataFlag) Set(s string) error {
+	key, val, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return errors.New("want key=value")
+	}
+	var v any
+	if err := json.Unmarshal([]byte(val), &v); err != nil {
+		v = val
+	}
+	m[key] = v
+	return nil
+}
+
+// newFlagSet returns a flag set for a subcommand that reports errors instead
+// of exiting.
+func newFlagSet(name, args string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: watsontcp %s [flags] %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses args into fs, mapping flag errors to errUsage.
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	return nil
+}
+
+// timeoutFlag registers the -timeout flag shared by the client subcommands.
+func timeoutFlag(fs *flag.FlagSet) *time.Duration {
+	return fs.Duration("timeout", 10*time.Second, "connect, send and response `timeout`")
+}
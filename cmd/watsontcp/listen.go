@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/WasimAhmad/watsontcp-go/client"
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// runListen connects as a client and prints what the server sends until
+// interrupted or disconnected. Like serve, it answers sync requests, echoing
+// them with -echo. Losing the connection for any reason other than a normal
+// close or server shutdown is an error.
+func runListen(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var f commonFlags
+	fs := newFlagSet("listen", "", stderr)
+	f.register(fs, false)
+	echo := fs.Bool("echo", false, "send every message back to the server")
+	recvDir := fs.String("recv-dir", "", "accept files the server sends with SendFile into `dir`")
+	timeout := timeoutFlag(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	out := &printer{w: stdout, json: f.json}
+	peer := f.addr
+
+	// cli is set once Connect returns; callbacks wait for it before replying.
+	var cli *client.Client
+	ready := make(chan struct{})
+	lost := make(chan message.DisconnectReason, 1)
+	cb := client.Callbacks{
+		OnMessage: func(msg *message.Message, data []byte) {
+			<-ready
+			out.print(received(peer, msg, data))
+			if resp, reply := answer(msg, data, *echo); resp != nil {
+				cli.Send(resp, reply)
+			}
+		},
+		OnDisconnect: func(reason message.DisconnectReason) {
+			lost <- reason
+		},
+		OnError: func(err error) {
+			out.print(event{Event: "error", Peer: peer, Error: err.Error()})
+		},
+	}
+	var err error
+	cli, err = f.connect(cb, *timeout, stderr)
+	close(ready)
+	if err != nil {
+		return err
+	}
+	if *recvDir != "" {
+		if err := os.MkdirAll(*recvDir, 0o755); err != nil {
+			cli.Disconnect()
+			return err
+		}
+		cli.ReceiveFile(*recvDir, func(name, path string) {
+			out.print(event{Event: "file", Peer: peer, Name: name, Path: path})
+		})
+	}
+	out.print(event{Event: "connect", Peer: peer})
+
+	ctx, stop := interrupted()
+	defer stop()
+	select {
+	case <-ctx.Done():
+		cli.Disconnect()
+		return nil
+	case reason := <-lost:
+		out.print(event{Event: "disconnect", Peer: peer, Reason: reason.String()})
+		if reason == message.DisconnectNormal || reason == message.DisconnectShutdown {
+			return nil
+		}
+		return fmt.Errorf("disconnected: %s", reason)
+	}
+}
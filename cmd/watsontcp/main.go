@@ -0,0 +1,95 @@
+// Command watsontcp talks WatsonTcp from a shell. It can run a server that
+// logs or echoes traffic, send a message or file, make a sync call and print
+// the response, connect as a client and print what the server sends, or
+// benchmark a server.
+// Every subcommand supports TLS, preshared keys and JSON output, so it works
+// against both C# and Go peers.
+//
+// Usage:
+//
+//	watsontcp serve  [flags]
+//	watsontcp send   [flags] [data...]
+//	watsontcp call   [flags] [data...]
+//	watsontcp listen [flags]
+//	watsontcp bench  [flags]
+//
+// Run a subcommand with -h for its flags.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// errUsage reports a command line error. The flag package has already
+// printed the details.
+var errUsage = errors.New("usage")
+
+var commands = []struct {
+	name    string
+	summary string
+	run     func(args []string, stdin io.Reader, stdout, stderr io.Writer) error
+}{
+	{"serve", "run a server that logs or echoes messages", runServe},
+	{"send", "send a message or file to a server", runSend},
+	{"call", "send a sync request and print the response", runCall},
+	{"listen", "connect to a server and print the messages it sends", runListen},
+	{"bench", "measure throughput and latency against a server", runBench},
+}
+
+// interrupted returns a context that is canceled when the process is
+// interrupted or terminated. Tests replace it to stop serve and listen.
+var interrupted = func() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		usage(stderr)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		err := cmd.run(args[1:], stdin, stdout, stderr)
+		switch {
+		case err == nil:
+			return 0
+		case errors.Is(err, flag.ErrHelp):
+			return 0
+		case errors.Is(err, errUsage):
+			return 2
+		default:
+			fmt.Fprintf(stderr, "watsontcp %s: %v\n", cmd.name, err)
+			return 1
+		}
+	}
+	fmt.Fprintf(stderr, "watsontcp: unknown command %q\n", args[0])
+	usage(stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: watsontcp <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'watsontcp <command> -h' for the flags of a command.")
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes of callbacks.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetadataFlag(t *testing.T) {
+	m := metadataFlag{}
+	for _, s := range []string{"n=5", "s=five", "ok=true", `obj={"a":[1,2]}`, "empty=", "eq=a=b"} {
+		if err := m.Set(s); err != nil {
+			t.Fatalf("Set(%q): %v", s, err)
+		}
+	}
+	want := metadataFlag{
+		"n":     float64(5),
+		"s":     "five",
+		"ok":    true,
+		"obj":   map[string]any{"a": []any{float64(1), float64(2)}},
+		"empty": "",
+		"eq":    "a=b",
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("got %#v, want %#v", m, want)
+	}
+	for _, s := range []string{"novalue", "=value", ""} {
+		if err := m.Set(s); err == nil {
+			t.Fatalf("Set(%q) accepted", s)
+		}
+	}
+	if got := (metadataFlag{"k": "v"}).String(); got != `{"k":"v"}` {
+		t.Fatalf("String() = %s", got)
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	md := map[string]any{"k": "v"}
+	req := &message.Message{SyncRequest: true, ConversationGUID: "c1", Metadata: md}
+	msg := &message.Message{Metadata: md}
+	tests := []struct {
+		name     string
+		msg      *message.Message
+		echo     bool
+		want     *message.Message
+		wantData string
+	}{
+		{"sync echo", req, true, &message.Message{SyncResponse: true, ConversationGUID: "c1", Metadata: md}, "hi"},
+		{"sync", req, false, &message.Message{SyncResponse: true, ConversationGUID: "c1"}, ""},
+		{"echo", msg, true, &message.Message{Metadata: md}, "hi"},
+		{"plain", msg, false, nil, ""},
+	}
+	for _, tt := range tests {
+		resp, data := answer(tt.msg, []byte("hi"), tt.echo)
+		if !reflect.DeepEqual(resp, tt.want) || string(data) != tt.wantData {
+			t.Errorf("%s: got %+v %q, want %+v %q", tt.name, resp, data, tt.want, tt.wantData)
+		}
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 30, 45, 123e6, time.UTC)
+	msg := &message.Message{Status: message.StatusFailure, SyncRequest: true, Metadata: map[string]any{"k": "v"}}
+	text := messageEvent("request", "peer1", msg, []byte("hello"))
+	text.Time = at
+	binary := messageEvent("message", "peer1", &message.Message{}, []byte{0xff, 0x00})
+	binary.Time = at
+	events := []event{
+		text,
+		binary,
+		{Time: at, Event: "listening", Peer: "127.0.0.1:9000"},
+		{Time: at, Event: "disconnect", Peer: "peer1", Reason: "Normal"},
+		{Time: at, Event: "sent", Peer: "srv", Length: 3},
+		{Time: at, Event: "file", Peer: "peer1", Name: "a.txt", Path: "in/a.txt"},
+		{Time: at, Event: "error", Peer: "peer1", Error: "boom"},
+	}
+
+	var out bytes.Buffer
+	p := &printer{w: &out}
+	for _, e := range events {
+		p.print(e)
+	}
+	want := strings.Join([]string{
+		`12:30:45.123 peer1 request Failure {"k":"v"}: hello`,
+		`12:30:45.123 peer1 message: <2 bytes>`,
+		`12:30:45.123 listening on 127.0.0.1:9000`,
+		`12:30:45.123 peer1 disconnected (Normal)`,
+		`12:30:45.123 srv sent 3 bytes`,
+		`12:30:45.123 peer1 file a.txt saved to in/a.txt`,
+		`12:30:45.123 peer1 error: boom`,
+	}, "\n") + "\n"
+	if out.String() != want {
+		t.Fatalf("text output:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	p = &printer{w: &out, json: true}
+	p.print(text)
+	p.print(binary)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one JSON object per line, got %q", out.String())
+	}
+	var got event
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Time.Equal(at) || got.Event != "request" || !got.Sync || got.Status != "Failure" ||
+		got.Data != "hello" || got.Length != 5 || got.Metadata["k"] != "v" {
+		t.Fatalf("unexpected JSON event %s", lines[0])
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Encoding != "base64" || got.Data != "/wA=" {
+		t.Fatalf("binary payload not base64 encoded: %s", lines[1])
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var stderr bytes.Buffer
+	for _, args := range [][]string{nil, {"bogus"}, {"send", "-bogus"}} {
+		stderr.Reset()
+		if code := run(args, nil, &bytes.Buffer{}, &stderr); code != 2 {
+			t.Fatalf("run(%q) = %d, want 2", args, code)
+		}
+		if !strings.Contains(stderr.String(), "Usage:") {
+			t.Fatalf("run(%q) printed no usage: %s", args, stderr.String())
+		}
+	}
+	if code := run([]string{"call", "-h"}, nil, &bytes.Buffer{}, &stderr); code != 0 {
+		t.Fatalf("-h exit code %d", code)
+	}
+}
+
+func TestServeAndCall(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	orig := interrupted
+	interrupted = func() (context.Context, context.CancelFunc) { return ctx, stop }
+	t.Cleanup(func() { interrupted = orig })
+
+	const addr = "127.0.0.1:30360"
+	recvDir := t.TempDir()
+	var serveOut, serveErr syncBuffer
+	served := make(chan int, 1)
+	go func() {
+		served <- run([]string{"serve", "-addr", addr, "-echo", "-recv-dir", recvDir}, nil, &serveOut, &serveErr)
+	}()
+	waitFor(t, func() bool { return strings.Contains(serveOut.String(), "listening on "+addr) })
+
+	var out, errOut bytes.Buffer
+	if code := run([]string{"call", "-addr", addr, "-md", "n=5", "hello", "there"}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("call exit code %d: %s", code, errOut.String())
+	}
+	if out.String() != "hello there" {
+		t.Fatalf("call printed %q", out.String())
+	}
+	waitFor(t, func() bool { return strings.Contains(serveOut.String(), `request {"n":5}: hello there`) })
+
+	out.Reset()
+	if code := run([]string{"call", "-addr", addr, "-json", "-md", "k=v"}, strings.NewReader("from stdin"), &out, &errOut); code != 0 {
+		t.Fatalf("call -json exit code %d: %s", code, errOut.String())
+	}
+	var resp event
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		t.Fatalf("call -json output %q: %v", out.String(), err)
+	}
+	if resp.Event != "response" || resp.Data != "from stdin" || resp.Metadata["k"] != "v" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	src := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(src, []byte("resumable"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if code := run([]string{"send", "-addr", addr, "-transfer", src}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("send -transfer exit code %d: %s", code, errOut.String())
+	}
+	if got, err := os.ReadFile(filepath.Join(recvDir, "notes.txt")); err != nil || string(got) != "resumable" {
+		t.Fatalf("transferred file %q: %v", got, err)
+	}
+	if !strings.Contains(out.String(), "sent file "+src) {
+		t.Fatalf("send printed %q", out.String())
+	}
+
+	if code := run([]string{"call", "-addr", "127.0.0.1:30369", "-timeout", "200ms", "x"}, nil, &out, &errOut); code != 1 {
+		t.Fatalf("call to a closed port exit code %d", code)
+	}
+
+	stop()
+	select {
+	case code := <-served:
+		if code != 0 {
+			t.Fatalf("serve exit code %d: %s", code, serveErr.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("serve did not stop")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// event is one line of output.
+type event struct {
+	Time     time.Time      `json:"time"`
+	Event    string         `json:"event"`
+	Peer     string         `json:"peer,omitempty"`
+	Status   string         `json:"status,omitempty"`
+	Sync     bool           `json:"sync,omitempty"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Length   int            `json:"length,omitempty"`
+
+	// Data is the payload, base64 encoded when Encoding is "base64"
+	// because it is not valid UTF-8.
+	Data     string `json:"data,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+
+	Reason string `json:"reason,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// messageEvent describes a received message.
+func messageEvent(kind, peer string, msg *message.Message, data []byte) event {
+	e := event{
+		Event:    kind,
+		Peer:     peer,
+		Sync:     msg.SyncRequest,
+		Metadata: msg.Metadata,
+		Length:   len(data),
+	}
+	if msg.Status != "" && msg.Status != message.StatusNormal {
+		e.Status = string(msg.Status)
+	}
+	if utf8.Valid(data) {
+		e.Data = string(data)
+	} else {
+		e.Data = base64.StdEncoding.EncodeToString(data)
+		e.Encoding = "base64"
+	}
+	return e
+}
+
+// printer writes events as text or JSON lines. It is safe for concurrent
+// use by callbacks.
+type printer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	json bool
+}
+
+func (p *printer) print(e event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if p.json {
+		b, _ := json.Marshal(e)
+		fmt.Fprintf(p.w, "%s\n", b)
+		return
+	}
+	fmt.Fprintln(p.w, text(e))
+}
+
+func text(e event) string {
+	prefix := e.Time.Format("15:04:05.000")
+	if e.Peer != "" {
+		prefix += " " + e.Peer
+	}
+	switch e.Event {
+	case "message", "request", "response":
+		s := fmt.Sprintf("%s %s", prefix, e.Event)
+		if e.Status != "" {
+			s += " " + e.Status
+		}
+		if len(e.Metadata) > 0 {
+			md, _ := json.Marshal(e.Metadata)
+			s += " " + string(md)
+		}
+		if e.Encoding != "" {
+			return fmt.Sprintf("%s: <%d bytes>", s, e.Length)
+		}
+		return fmt.Sprintf("%s: %s", s, e.Data)
+	case "listening":
+		return fmt.Sprintf("%s listening on %s", e.Time.Format("15:04:05.000"), e.Peer)
+	case "connect":
+		return fmt.Sprintf("%s connected", prefix)
+	case "disconnect":
+		return fmt.Sprintf("%s disconnected (%s)", prefix, e.Reason)
+	case "sent":
+		if e.Path != "" {
+			return fmt.Sprintf("%s sent file %s", prefix, e.Path)
+		}
+		return fmt.Sprintf("%s sent %d bytes", prefix, e.Length)
+	case "rejected":
+		return fmt.Sprintf("%s rejected (%s)", prefix, e.Reason)
+	case "file":
+		return fmt.Sprintf("%s file %s saved to %s", prefix, e.Name, e.Path)
+	case "error":
+		return fmt.Sprintf("%s error: %s", prefix, e.Error)
+	default:
+		return fmt.Sprintf("%s %s", prefix, e.Event)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/client"
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// messageFlags are the flags of send and call that describe the message.
+type messageFlags struct {
+	commonFlags
+	metadata metadataFlag
+	file     string
+	timeout  *time.Duration
+}
+
+func (f *messageFlags) register(name string, stderr io.Writer) *flag.FlagSet {
+	fs := newFlagSet(name, "[data...]", stderr)
+	f.commonFlags.register(fs, false)
+	f.metadata = metadataFlag{}
+	fs.Var(f.metadata, "md", "add metadata `key=value`; the value is JSON if it parses, else a string (repeatable)")
+	fs.StringVar(&f.file, "file", "", "send the contents of `path` as the payload")
+	f.timeout = timeoutFlag(fs)
+	return fs
+}
+
+// connect opens a client connection with the common flags.
+func (f *commonFlags) connect(cb client.Callbacks, timeout time.Duration, stderr io.Writer) (*client.Client, error) {
+	tlsConf, err := f.clientTLS()
+	if err != nil {
+		return nil, err
+	}
+	opts := client.DefaultOptions()
+	opts.ConnectTimeout = timeout
+	opts.PresharedKey = f.psk
+	opts.Logger = f.logger(stderr)
+	opts.DebugMessages = f.debug
+	cli := client.New(f.addr, tlsConf, cb, &opts)
+	if err := cli.Connect(); err != nil {
+		return nil, err
+	}
+	return cli, nil
+}
+
+// payload returns the data to send: the -file contents, the arguments
+// joined by spaces, or stdin when there are neither.
+func (f *messageFlags) payload(args []string, stdin io.Reader) ([]byte, error) {
+	switch {
+	case f.file != "":
+		if len(args) > 0 {
+			return nil, errors.New("give either -file or data arguments, not both")
+		}
+		return os.ReadFile(f.file)
+	case len(args) > 0:
+		return []byte(strings.Join(args, " ")), nil
+	default:
+		return io.ReadAll(stdin)
+	}
+}
+
+func (f *messageFlags) message() *message.Message {
+	msg := &message.Message{}
+	if len(f.metadata) > 0 {
+		msg.Metadata = f.metadata
+	}
+	return msg
+}
+
+// runSend sends one message, or with -transfer a file using the resumable
+// file transfer protocol, and exits.
+func runSend(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var f messageFlags
+	fs := f.register("send", stderr)
+	transfer := fs.String("transfer", "", "transfer the file at `path` with the resumable file protocol; the peer must run 'serve -recv-dir'")
+	transferTimeout := fs.Duration("transfer-timeout", 0, "give up a -transfer after `duration`; 0 waits for it to finish")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	out := &printer{w: stdout, json: f.json}
+	var data []byte
+	if *transfer == "" && f.file == "" {
+		var err error
+		if data, err = f.payload(fs.Args(), stdin); err != nil {
+			return err
+		}
+	} else if fs.NArg() > 0 {
+		return errors.New("data arguments cannot be combined with -file or -transfer")
+	}
+	cli, err := f.connect(client.Callbacks{}, *f.timeout, stderr)
+	if err != nil {
+		return err
+	}
+	defer cli.Disconnect()
+
+	if *transfer != "" {
+		// -timeout covers connecting; a large file may take much longer
+		ctx := context.Background()
+		if *transferTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *transferTimeout)
+			defer cancel()
+		}
+		if err := cli.SendFile(ctx, *transfer); err != nil {
+			return err
+		}
+		out.print(event{Event: "sent", Peer: f.addr, Name: filepath.Base(*transfer), Path: *transfer})
+		return nil
+	}
+	if f.file != "" {
+		n, err := sendFile(cli, f.message(), f.file)
+		if err != nil {
+			return err
+		}
+		out.print(event{Event: "sent", Peer: f.addr, Length: int(n)})
+		return nil
+	}
+	if err := cli.Send(f.message(), data); err != nil {
+		return err
+	}
+	out.print(event{Event: "sent", Peer: f.addr, Length: len(data)})
+	return nil
+}
+
+// sendFile streams the file at path as the payload of msg without reading
+// it into memory.
+func sendFile(cli *client.Client, msg *message.Message, path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), cli.SendStream(msg, file, info.Size())
+}
+
+// runCall sends a sync request and prints the response. In text mode the
+// response payload is written to stdout unchanged, so it can be piped; use
+// -json to see metadata and status as well. A StatusFailure response is an
+// error.
+func runCall(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var f messageFlags
+	fs := f.register("call", stderr)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	data, err := f.payload(fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+	cli, err := f.connect(client.Callbacks{}, *f.timeout, stderr)
+	if err != nil {
+		return err
+	}
+	defer cli.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *f.timeout)
+	defer cancel()
+	resp, reply, err := cli.SendSync(ctx, f.message(), data)
+	if err != nil {
+		return err
+	}
+	if f.json {
+		(&printer{w: stdout, json: true}).print(messageEvent("response", f.addr, resp, reply))
+	} else if _, err := stdout.Write(reply); err != nil {
+		return err
+	}
+	if resp.Status == message.StatusFailure {
+		reason, _ := resp.Metadata[message.MetadataError].(string)
+		return fmt.Errorf("request failed: %s", reason)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+	"github.com/WasimAhmad/watsontcp-go/server"
+)
+
+// runServe runs a server that prints every connection and message until
+// interrupted. Sync requests are always answered so callers do not hang:
+// with -echo the response carries the request payload and metadata,
+// otherwise it is empty.
+func runServe(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var f commonFlags
+	fs := newFlagSet("serve", "", stderr)
+	f.register(fs, true)
+	echo := fs.Bool("echo", false, "send every message back to its sender")
+	recvDir := fs.String("recv-dir", "", "accept files sent with 'send -transfer' into `dir`")
+	maxConns := fs.Int("max-conns", 0, "maximum number of clients; 0 is unlimited")
+	idle := fs.Duration("idle-timeout", 0, "disconnect clients idle for `duration`; 0 never does")
+	pubsub := fs.Bool("pubsub", false, "enable publish/subscribe between clients")
+	relay := fs.Bool("relay", false, "enable client-to-client relay")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	tlsConf, err := f.serverTLS()
+	if err != nil {
+		return err
+	}
+
+	out := &printer{w: stdout, json: f.json}
+	opts := server.DefaultOptions()
+	opts.PresharedKey = f.psk
+	opts.MaxConnections = *maxConns
+	opts.IdleTimeout = *idle
+	opts.PubSub = *pubsub
+	opts.Relay = *relay
+	opts.Logger = f.logger(stderr)
+	opts.DebugMessages = f.debug
+
+	var srv *server.Server
+	cb := server.Callbacks{
+		OnConnect: func(id string, _ net.Conn) {
+			out.print(event{Event: "connect", Peer: id})
+		},
+		OnDisconnect: func(id string, reason message.DisconnectReason) {
+			out.print(event{Event: "disconnect", Peer: id, Reason: reason.String()})
+		},
+		OnMessage: func(id string, msg *message.Message, data []byte) {
+			out.print(received(id, msg, data))
+			// write errors reach OnError
+			if resp, reply := answer(msg, data, *echo); resp != nil {
+				srv.Send(id, resp, reply)
+			}
+		},
+		OnRejected: func(addr net.Addr, reason string) {
+			out.print(event{Event: "rejected", Peer: addr.String(), Reason: reason})
+		},
+		OnError: func(id string, err error) {
+			out.print(event{Event: "error", Peer: id, Error: err.Error()})
+		},
+	}
+	srv = server.New(f.addr, tlsConf, cb, &opts)
+	if *recvDir != "" {
+		if err := os.MkdirAll(*recvDir, 0o755); err != nil {
+			return err
+		}
+		srv.ReceiveFile(*recvDir, func(id, name, path string) {
+			out.print(event{Event: "file", Peer: id, Name: name, Path: path})
+		})
+	}
+
+	ctx, stop := interrupted()
+	defer stop()
+	if err := srv.Start(); err != nil {
+		return err
+	}
+	defer srv.Stop()
+	out.print(event{Event: "listening", Peer: f.addr})
+	<-ctx.Done()
+	return nil
+}
+
+// received describes an incoming message or sync request.
+func received(peer string, msg *message.Message, data []byte) event {
+	if msg.SyncRequest {
+		return messageEvent("request", peer, msg, data)
+	}
+	return messageEvent("message", peer, msg, data)
+}
+
+// answer returns the reply due for msg, or nil when none is. Sync requests
+// are always answered, echoing them when echo is set; other messages are
+// sent back only when echo is set.
+func answer(msg *message.Message, data []byte, echo bool) (*message.Message, []byte) {
+	switch {
+	case msg.SyncRequest && echo:
+		return &message.Message{SyncResponse: true, ConversationGUID: msg.ConversationGUID, Metadata: msg.Metadata}, data
+	case msg.SyncRequest:
+		return &message.Message{SyncResponse: true, ConversationGUID: msg.ConversationGUID}, nil
+	case echo:
+		return &message.Message{Metadata: msg.Metadata}, data
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,173 @@
+// Package codec encodes typed values into WatsonTcp payloads. The encoding
+// used for a payload is recorded in message metadata under
+// message.MetadataContentType so that the receiver can decode it.
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// Codec marshals values to and from payload bytes.
+type Codec interface {
+	// ContentType identifies the encoding, for example "application/json".
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSON encodes values with encoding/json. It is used when a message
+	// carries no content type.
+	JSON Codec = jsonCodec{}
+
+	// Gob encodes values with encoding/gob.
+	Gob Codec = gobCodec{}
+)
+
+// ErrUnknownContentType is wrapped by errors for payloads whose content type
+// has no registered Codec.
+var ErrUnknownContentType = errors.New("codec: unknown content type")
+
+// EncodeError reports a failure to marshal a value.
+type EncodeError struct {
+	ContentType string
+	Err         error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("codec: encode %s: %v", e.ContentType, e.Err)
+}
+
+func (e *EncodeError) Unwrap() error { return e.Err }
+
+// DecodeError reports a failure to unmarshal a payload.
+type DecodeError struct {
+	ContentType string
+	Err         error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("codec: decode %s: %v", e.ContentType, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Codec{
+		JSON.ContentType(): JSON,
+		Gob.ContentType():  Gob,
+	}
+)
+
+// Register makes c available for decoding payloads tagged with its content
+// type, replacing any codec previously registered for it.
+func Register(c Codec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[c.ContentType()] = c
+}
+
+// Lookup returns the codec registered for contentType.
+func Lookup(contentType string) (Codec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, ok := registry[contentType]
+	return c, ok
+}
+
+// ForMessage returns the codec named by the content type in msg metadata,
+// or JSON when none is set.
+func ForMessage(msg *message.Message) (Codec, error) {
+	if msg == nil {
+		return JSON, nil
+	}
+	ct, _ := msg.Metadata[message.MetadataContentType].(string)
+	if ct == "" {
+		return JSON, nil
+	}
+	c, ok := Lookup(ct)
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownContentType, ct)
+	}
+	return c, nil
+}
+
+// Encode marshals v with the codec selected by msg and records the content
+// type in msg metadata.
+func Encode(msg *message.Message, v any) ([]byte, error) {
+	c, err := ForMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeWith(c, msg, v)
+}
+
+// EncodeWith marshals v with c and records the content type in msg metadata.
+func EncodeWith(c Codec, msg *message.Message, v any) ([]byte, error) {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return nil, &EncodeError{ContentType: c.ContentType(), Err: err}
+	}
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]any)
+	}
+	msg.Metadata[message.MetadataContentType] = c.ContentType()
+	return data, nil
+}
+
+// Decode unmarshals data into a T using the codec named by msg.
+func Decode[T any](msg *message.Message, data []byte) (T, error) {
+	var v T
+	c, err := ForMessage(msg)
+	if err != nil {
+		return v, err
+	}
+	err = Unmarshal(c, data, &v)
+	return v, err
+}
+
+// Unmarshal decodes data into v with c. Errors and panics from third-party
+// codecs surface as a *DecodeError.
+func Unmarshal(c Codec, data []byte, v any) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &DecodeError{ContentType: c.ContentType(), Err: fmt.Errorf("panic: %v", r)}
+		}
+	}()
+	if err := c.Unmarshal(data, v); err != nil {
+		return &DecodeError{ContentType: c.ContentType(), Err: err}
+	}
+	return nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string { return "application/x-gob" }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
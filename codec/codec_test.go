@@ -0,0 +1,120 @@
+package codec_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/client"
+	"github.com/WasimAhmad/watsontcp-go/codec"
+	"github.com/WasimAhmad/watsontcp-go/message"
+	"github.com/WasimAhmad/watsontcp-go/router"
+	"github.com/WasimAhmad/watsontcp-go/server"
+)
+
+type point struct {
+	X, Y int
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, c := range []codec.Codec{codec.JSON, codec.Gob} {
+		msg := &message.Message{}
+		data, err := codec.EncodeWith(c, msg, point{1, 2})
+		if err != nil {
+			t.Fatalf("%s encode: %v", c.ContentType(), err)
+		}
+		if msg.Metadata[message.MetadataContentType] != c.ContentType() {
+			t.Fatalf("content type not recorded")
+		}
+		p, err := codec.Decode[point](msg, data)
+		if err != nil || p != (point{1, 2}) {
+			t.Fatalf("%s decode: %v %+v", c.ContentType(), err, p)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, err := codec.Decode[point](&message.Message{}, []byte("{not json"))
+	var de *codec.DecodeError
+	if !errors.As(err, &de) || de.ContentType != "application/json" {
+		t.Fatalf("expected DecodeError, got %v", err)
+	}
+
+	msg := &message.Message{Metadata: map[string]any{message.MetadataContentType: "text/unknown"}}
+	if _, err := codec.Decode[point](msg, nil); !errors.Is(err, codec.ErrUnknownContentType) {
+		t.Fatalf("expected ErrUnknownContentType, got %v", err)
+	}
+}
+
+func TestCallRoundTrip(t *testing.T) {
+	rt := router.New("op")
+	rt.Handle("add", codec.Handler(func(req *router.Request, in point) (int, error) {
+		return in.X + in.Y, nil
+	}))
+	rt.Handle("fail", codec.Handler(func(req *router.Request, in point) (int, error) {
+		return 0, errors.New("nope")
+	}))
+
+	var srv *server.Server
+	ids := make(chan string, 1)
+	cb := server.Callbacks{
+		OnConnect: func(id string, conn net.Conn) { ids <- id },
+		OnMessage: rt.OnServerMessage(func(id string, msg *message.Message, data []byte) error {
+			return srv.Send(id, msg, data)
+		}),
+	}
+	srv = server.New("127.0.0.1:30140", nil, cb, nil)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	var cli *client.Client
+	cliRouter := router.New("op")
+	cliRouter.Handle("upper", codec.Handler(func(req *router.Request, in string) (string, error) {
+		return strings.ToUpper(in), nil
+	}))
+	cli = client.New("127.0.0.1:30140", nil, client.Callbacks{
+		OnMessage: cliRouter.OnClientMessage(func(msg *message.Message, data []byte) error {
+			return cli.Send(msg, data)
+		}),
+	}, nil)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+	id := <-ids
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	msg := &message.Message{Metadata: map[string]any{"op": "add"}}
+	sum, err := codec.CallWith[point, int](ctx, cli, msg, point{3, 4})
+	if err != nil || sum != 7 {
+		t.Fatalf("call add: %v %d", err, sum)
+	}
+
+	msg = &message.Message{Metadata: map[string]any{
+		"op":                        "add",
+		message.MetadataContentType: codec.Gob.ContentType(),
+	}}
+	if sum, err = codec.CallWith[point, int](ctx, cli, msg, point{5, 6}); err != nil || sum != 11 {
+		t.Fatalf("gob call add: %v %d", err, sum)
+	}
+
+	msg = &message.Message{Metadata: map[string]any{"op": "fail"}}
+	_, err = codec.CallWith[point, int](ctx, cli, msg, point{})
+	var re *codec.RemoteError
+	if !errors.As(err, &re) || re.Reason != "nope" {
+		t.Fatalf("expected RemoteError, got %v", err)
+	}
+
+	msg = &message.Message{Metadata: map[string]any{"op": "upper"}}
+	up, err := codec.CallClientWith[string, string](ctx, srv, id, msg, "hello")
+	if err != nil || up != "HELLO" {
+		t.Fatalf("call client: %v %q", err, up)
+	}
+}
@@ -0,0 +1,137 @@
+package codec
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/WasimAhmad/watsontcp-go/client"
+	"github.com/WasimAhmad/watsontcp-go/message"
+	"github.com/WasimAhmad/watsontcp-go/router"
+	"github.com/WasimAhmad/watsontcp-go/server"
+)
+
+// RemoteError is returned by the call helpers when the peer answers with
+// StatusFailure.
+type RemoteError struct {
+	Status message.MessageStatus
+	Reason string
+}
+
+func (e *RemoteError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("codec: remote returned %s", e.Status)
+	}
+	return fmt.Sprintf("codec: remote returned %s: %s", e.Status, e.Reason)
+}
+
+// SendTyped encodes v and sends it to the server. msg may be nil; its
+// content type, if set, selects the codec.
+func SendTyped[T any](c *client.Client, msg *message.Message, v T) error {
+	if msg == nil {
+		msg = &message.Message{}
+	}
+	data, err := Encode(msg, v)
+	if err != nil {
+		return err
+	}
+	return c.Send(msg, data)
+}
+
+// SendTypedTo encodes v and sends it to the client identified by id. msg may
+// be nil; its content type, if set, selects the codec.
+func SendTypedTo[T any](s *server.Server, id string, msg *message.Message, v T) error {
+	if msg == nil {
+		msg = &message.Message{}
+	}
+	data, err := Encode(msg, v)
+	if err != nil {
+		return err
+	}
+	return s.Send(id, msg, data)
+}
+
+// Call sends req to the server as a JSON-encoded sync request and decodes
+// the response into a Resp.
+func Call[Req, Resp any](ctx context.Context, c *client.Client, req Req) (Resp, error) {
+	return CallWith[Req, Resp](ctx, c, nil, req)
+}
+
+// CallWith is like Call but sends msg, whose metadata may select the codec.
+func CallWith[Req, Resp any](ctx context.Context, c *client.Client, msg *message.Message, req Req) (Resp, error) {
+	return call[Req, Resp](msg, req, func(m *message.Message, data []byte) (*message.Message, []byte, error) {
+		return c.SendSync(ctx, m, data)
+	})
+}
+
+// CallClient sends req to the client identified by id as a JSON-encoded sync
+// request and decodes the response into a Resp.
+func CallClient[Req, Resp any](ctx context.Context, s *server.Server, id string, req Req) (Resp, error) {
+	return CallClientWith[Req, Resp](ctx, s, id, nil, req)
+}
+
+// CallClientWith is like CallClient but sends msg, whose metadata may select
+// the codec.
+func CallClientWith[Req, Resp any](ctx context.Context, s *server.Server, id string, msg *message.Message, req Req) (Resp, error) {
+	return call[Req, Resp](msg, req, func(m *message.Message, data []byte) (*message.Message, []byte, error) {
+		return s.SendSync(ctx, id, m, data)
+	})
+}
+
+func call[Req, Resp any](msg *message.Message, req Req, sendSync func(*message.Message, []byte) (*message.Message, []byte, error)) (Resp, error) {
+	var out Resp
+	if msg == nil {
+		msg = &message.Message{}
+	}
+	c, err := ForMessage(msg)
+	if err != nil {
+		return out, err
+	}
+	data, err := EncodeWith(c, msg, req)
+	if err != nil {
+		return out, err
+	}
+	resp, respData, err := sendSync(msg, data)
+	if err != nil {
+		return out, err
+	}
+	if resp.Status == message.StatusFailure {
+		reason, _ := resp.Metadata[message.MetadataError].(string)
+		return out, &RemoteError{Status: resp.Status, Reason: reason}
+	}
+	// replies without a content type are assumed to use the request codec
+	if ct, _ := resp.Metadata[message.MetadataContentType].(string); ct != "" {
+		if c, err = ForMessage(resp); err != nil {
+			return out, err
+		}
+	}
+	err = Unmarshal(c, respData, &out)
+	return out, err
+}
+
+// Handler adapts a typed function to a router.Handler. The request payload is
+// decoded into a Req and the returned Resp is encoded with the same codec.
+// Decode failures are answered with StatusFailure.
+func Handler[Req, Resp any](fn func(req *router.Request, in Req) (Resp, error)) router.Handler {
+	return func(req *router.Request) (*router.Response, error) {
+		c, err := ForMessage(req.Message)
+		if err != nil {
+			return nil, err
+		}
+		var in Req
+		if err := Unmarshal(c, req.Data, &in); err != nil {
+			return nil, err
+		}
+		out, err := fn(req, in)
+		if err != nil {
+			return nil, err
+		}
+		data, err := c.Marshal(out)
+		if err != nil {
+			return nil, &EncodeError{ContentType: c.ContentType(), Err: err}
+		}
+		return &router.Response{
+			Metadata: map[string]any{message.MetadataContentType: c.ContentType()},
+			Data:     data,
+		}, nil
+	}
+}
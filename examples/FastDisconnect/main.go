@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/WasimAhmad/watsontcp-go/client"
+	"github.com/WasimAhmad/watsontcp-go/message"
 	"github.com/WasimAhmad/watsontcp-go/server"
 )
 
@@ -26,10 +27,10 @@ func main() {
 			fmt.Printf("connect %s (active %d)\n", id, active)
 			mu.Unlock()
 		},
-		OnDisconnect: func(id string) {
+		OnDisconnect: func(id string, reason message.DisconnectReason) {
 			mu.Lock()
 			active--
-			fmt.Printf("disconnect %s (active %d)\n", id, active)
+			fmt.Printf("disconnect %s: %s (active %d)\n", id, reason, active)
 			mu.Unlock()
 		},
 	}
@@ -23,8 +23,8 @@ func startServer() *server.Server {
 		OnConnect: func(id string, _ net.Conn) {
 			log.Printf("server: %s connected", id)
 		},
-		OnDisconnect: func(id string) {
-			log.Printf("server: %s disconnected", id)
+		OnDisconnect: func(id string, reason message.DisconnectReason) {
+			log.Printf("server: %s disconnected (%s)", id, reason)
 		},
 		OnMessage: func(id string, _ *message.Message, data []byte) {
 			log.Printf("server got from %s: %s", id, string(data))
@@ -41,7 +41,7 @@ func runClient(idx int, wg *sync.WaitGroup) {
 	defer wg.Done()
 	cb := client.Callbacks{
 		OnConnect:    func() { log.Printf("client %d connected", idx) },
-		OnDisconnect: func() { log.Printf("client %d disconnected", idx) },
+		OnDisconnect: func(reason message.DisconnectReason) { log.Printf("client %d disconnected (%s)", idx, reason) },
 	}
 	c := client.New(serverAddr, nil, cb, nil)
 	if err := c.Connect(); err != nil {
@@ -36,7 +36,7 @@ func startServer() *server.Server {
 			conn.Write(hdr)
 			conn.Write([]byte("ack"))
 		},
-		OnDisconnect: func(id string) { fmt.Println("[server] client disconnected") },
+		OnDisconnect: func(id string, reason message.DisconnectReason) { fmt.Println("[server] client disconnected:", reason) },
 	}
 	srv := server.New(addr, nil, cb, nil)
 	if err := srv.Start(); err != nil {
@@ -46,7 +46,7 @@ func startServer() *server.Server {
 }
 
 func runClient() {
-	cli := client.New(addr, nil, client.Callbacks{OnDisconnect: func() { fmt.Println("[client] disconnected") }}, nil)
+	cli := client.New(addr, nil, client.Callbacks{OnDisconnect: func(reason message.DisconnectReason) { fmt.Println("[client] disconnected:", reason) }}, nil)
 
 	for {
 		if err := cli.Connect(); err != nil {
@@ -0,0 +1,447 @@
+// Package chunk implements the chunked stream framing shared by the client
+// and server. A chunked stream is a sequence of ordinary frames tied together
+// by a stream id in metadata, numbered from zero and terminated by a frame
+// flagged as the end. Either peer may abort a stream with a cancel frame;
+// the sending side acknowledges a cancel from the receiver by echoing it so
+// that the receiver can forget the stream.
+//
+// Each inbound stream buffers a few chunks. When a consumer falls that far
+// behind, the read loop waits for it, which holds up every other message on
+// the connection. This is deliberate backpressure: dropping chunks would
+// corrupt the stream. Consumers that may stall should copy the stream to a
+// buffer or file, or close the reader to cancel it.
+package chunk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// DefaultSize is the chunk size used when none is configured.
+const DefaultSize = 64 * 1024
+
+// queueDepth is the number of chunks buffered per inbound stream before the
+// read loop blocks, stalling the whole connection until the consumer reads.
+const queueDepth = 16
+
+// Frame is the stream portion of a received message.
+type Frame struct {
+	ID     string
+	Sender string
+	Seq    int64
+	Length int64
+	End    bool
+	Cancel string
+	Data   []byte
+}
+
+// Parse extracts the stream frame carried by msg, if any.
+func Parse(msg *message.Message, data []byte) (Frame, bool) {
+	id, _ := msg.Metadata[message.MetadataStreamID].(string)
+	if id == "" {
+		return Frame{}, false
+	}
+	f := Frame{ID: id, Data: data}
+	f.Sender, _ = msg.Metadata[message.MetadataSender].(string)
+	f.Seq = toInt(msg.Metadata[message.MetadataStreamSeq], 0)
+	f.Length = toInt(msg.Metadata[message.MetadataStreamLength], -1)
+	f.End, _ = msg.Metadata[message.MetadataStreamEnd].(bool)
+	f.Cancel, _ = msg.Metadata[message.MetadataStreamCancel].(string)
+	return f, true
+}
+
+// toInt converts a metadata number, which arrives as float64 after JSON
+// decoding, returning def if v is not a number.
+func toInt(v any, def int64) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	}
+	return def
+}
+
+// Exact returns a reader that yields exactly n bytes from r and fails with
+// io.ErrUnexpectedEOF if r ends early.
+func Exact(r io.Reader, n int64) io.Reader {
+	return &exactReader{r: r, n: n}
+}
+
+type exactReader struct {
+	r io.Reader
+	n int64
+}
+
+func (e *exactReader) Read(p []byte) (int, error) {
+	if e.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > e.n {
+		p = p[:e.n]
+	}
+	n, err := e.r.Read(p)
+	e.n -= int64(n)
+	if err == io.EOF && e.n > 0 {
+		err = io.ErrUnexpectedEOF
+	} else if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// IsFrame reports whether msg belongs to a chunked stream.
+func IsFrame(msg *message.Message) bool {
+	_, ok := msg.Metadata[message.MetadataStreamID].(string)
+	return ok
+}
+
+// DataMessage builds frame seq of stream id. The first frame carries the
+// metadata of base; later frames only copy the relay destination so that
+// every frame follows the same route.
+func DataMessage(base *message.Message, id string, seq int64, end bool) *message.Message {
+	msg := &message.Message{Status: message.StatusNormal, Metadata: map[string]any{}}
+	if seq == 0 {
+		msg.Status = base.Status
+		msg.SyncRequest = base.SyncRequest
+		msg.ConversationGUID = base.ConversationGUID
+		msg.ExpirationUtc = base.ExpirationUtc
+		for k, v := range base.Metadata {
+			msg.Metadata[k] = v
+		}
+	} else if dest, ok := base.Metadata[message.MetadataDestination]; ok {
+		msg.Metadata[message.MetadataDestination] = dest
+	}
+	msg.Metadata[message.MetadataStreamID] = id
+	msg.Metadata[message.MetadataStreamSeq] = seq
+	if end {
+		msg.Metadata[message.MetadataStreamEnd] = true
+	}
+	return msg
+}
+
+// CancelMessage builds a frame aborting stream id. dest, if not empty, asks
+// the server to relay the frame to that client.
+func CancelMessage(id, reason, dest string) *message.Message {
+	if reason == "" {
+		reason = "canceled"
+	}
+	msg := &message.Message{
+		Status: message.StatusNormal,
+		Metadata: map[string]any{
+			message.MetadataStreamID:     id,
+			message.MetadataStreamCancel: reason,
+		},
+	}
+	if dest != "" {
+		msg.Metadata[message.MetadataDestination] = dest
+	}
+	return msg
+}
+
+// Send reads r until EOF and writes it through write as chunked stream id,
+// using chunks of up to size bytes. The first frame carries the metadata of
+// msg. Sending stops with a cancel frame when ctx is done, when reading
+// fails, or when the peer cancels the stream. write is called with a buffer
+// that is reused after it returns.
+func Send(ctx context.Context, d *Demux, id string, size int, msg *message.Message, r io.Reader, write func(*message.Message, []byte) error) error {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	dest, _ := msg.Metadata[message.MetadataDestination].(string)
+	o := d.Open(id)
+	defer d.Release(o)
+	buf := make([]byte, size)
+	var seq int64
+	for {
+		select {
+		case <-ctx.Done():
+			write(CancelMessage(id, ctx.Err().Error(), dest), nil)
+			return ctx.Err()
+		case <-o.Done():
+			// echo the cancel so the receiver can forget the stream
+			write(CancelMessage(id, o.reason, dest), nil)
+			return o.Err()
+		default:
+		}
+		n, err := r.Read(buf)
+		if err != nil && err != io.EOF {
+			write(CancelMessage(id, err.Error(), dest), nil)
+			return err
+		}
+		end := err == io.EOF
+		if n == 0 && !end {
+			continue
+		}
+		if err := write(DataMessage(msg, id, seq, end), buf[:n]); err != nil {
+			return err
+		}
+		if end {
+			return nil
+		}
+		seq++
+	}
+}
+
+// Demux tracks the chunked streams of one connection.
+type Demux struct {
+	cancel func(id, reason, dest string)
+
+	mu     sync.Mutex
+	in     map[string]*Reader
+	out    map[string]*Outbound
+	closed bool
+}
+
+// NewDemux creates a Demux. cancel writes a cancel frame for stream id to
+// the peer, relayed to dest if not empty, and is used when a local reader is
+// closed early.
+func NewDemux(cancel func(id, reason, dest string)) *Demux {
+	return &Demux{
+		cancel: cancel,
+		in:     make(map[string]*Reader),
+		out:    make(map[string]*Outbound),
+	}
+}
+
+// Handle processes a received frame and returns a Reader when the frame
+// opens a new inbound stream. It must only be called from the read loop.
+// While the stream's buffer is full it blocks until the consumer reads,
+// closes the reader or the Demux is closed, so a slow consumer holds up the
+// rest of the connection.
+func (d *Demux) Handle(f Frame) *Reader {
+	d.mu.Lock()
+	if f.Cancel != "" {
+		if o := d.out[f.ID]; o != nil {
+			d.mu.Unlock()
+			o.abort(f.Cancel)
+			return nil
+		}
+		r := d.in[f.ID]
+		delete(d.in, f.ID)
+		d.mu.Unlock()
+		if r != nil {
+			r.finish(fmt.Errorf("%w: %s", message.ErrStreamCanceled, f.Cancel))
+		}
+		return nil
+	}
+
+	r, known := d.in[f.ID]
+	var opened *Reader
+	if !known {
+		if f.Seq != 0 || d.closed {
+			// tail of a stream that was already forgotten
+			d.mu.Unlock()
+			return nil
+		}
+		r = d.newReader(f.ID, f.Sender)
+		d.in[f.ID] = r
+		opened = r
+	}
+	if f.End {
+		delete(d.in, f.ID)
+	}
+	d.mu.Unlock()
+
+	// a nil reader is a tombstone for a stream closed locally
+	if r != nil {
+		if len(f.Data) > 0 {
+			r.push(f.Data)
+		}
+		if f.End {
+			r.finish(nil)
+		}
+	}
+	return opened
+}
+
+// Fail ends the stream f belongs to with err and cancels it at the sender.
+// Later frames of the stream are ignored.
+func (d *Demux) Fail(f Frame, err error) {
+	d.mu.Lock()
+	r, known := d.in[f.ID]
+	if (!known && f.Seq != 0) || d.closed {
+		d.mu.Unlock()
+		return
+	}
+	if f.End {
+		delete(d.in, f.ID)
+	} else {
+		d.in[f.ID] = nil
+	}
+	d.mu.Unlock()
+	if r != nil {
+		r.finish(err)
+	}
+	d.cancel(f.ID, err.Error(), f.Sender)
+}
+
+func (d *Demux) newReader(id, sender string) *Reader {
+	r := &Reader{ch: make(chan []byte, queueDepth), closed: make(chan struct{}), done: make(chan struct{})}
+	r.onClose = func() {
+		d.mu.Lock()
+		cur, ok := d.in[id]
+		live := ok && cur == r
+		if live {
+			d.in[id] = nil
+		}
+		d.mu.Unlock()
+		if live {
+			d.cancel(id, "closed by receiver", sender)
+		}
+	}
+	return r
+}
+
+// Open registers a new outbound stream.
+func (d *Demux) Open(id string) *Outbound {
+	o := &Outbound{ID: id, done: make(chan struct{})}
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		o.abort("connection closed")
+		return o
+	}
+	d.out[id] = o
+	d.mu.Unlock()
+	return o
+}
+
+// Release forgets an outbound stream once its sender has finished.
+func (d *Demux) Release(o *Outbound) {
+	d.mu.Lock()
+	delete(d.out, o.ID)
+	d.mu.Unlock()
+}
+
+// Close fails every inbound stream with err and aborts every outbound
+// stream. Frames handled afterwards are ignored.
+func (d *Demux) Close(err error) {
+	d.mu.Lock()
+	in, out := d.in, d.out
+	d.in = make(map[string]*Reader)
+	d.out = make(map[string]*Outbound)
+	d.closed = true
+	d.mu.Unlock()
+	for _, r := range in {
+		if r != nil {
+			r.finish(err)
+		}
+	}
+	for _, o := range out {
+		o.abort(err.Error())
+	}
+}
+
+// Outbound is the sending side of a chunked stream.
+type Outbound struct {
+	ID string
+
+	once   sync.Once
+	done   chan struct{}
+	reason string
+}
+
+// Done is closed when the peer cancels the stream or the connection closes.
+func (o *Outbound) Done() <-chan struct{} { return o.done }
+
+// Err returns the cancellation reported by the peer, or nil.
+func (o *Outbound) Err() error {
+	select {
+	case <-o.done:
+		return fmt.Errorf("%w: %s", message.ErrStreamCanceled, o.reason)
+	default:
+		return nil
+	}
+}
+
+func (o *Outbound) abort(reason string) {
+	o.once.Do(func() {
+		o.reason = reason
+		close(o.done)
+	})
+}
+
+// Reader is the receiving side of a chunked stream. Closing it before the
+// end of the stream cancels the stream at the sender.
+type Reader struct {
+	ch      chan []byte
+	buf     []byte
+	closed  chan struct{}
+	once    sync.Once
+	onClose func()
+
+	// done is closed by finish once no more chunks will be queued; err is
+	// the reason, nil for a normal end.
+	done       chan struct{}
+	finishOnce sync.Once
+	err        error
+}
+
+// Read implements io.Reader. It returns io.EOF after the final chunk, or an
+// error wrapping message.ErrStreamCanceled if the stream was aborted.
+func (r *Reader) Read(p []byte) (int, error) {
+	select {
+	case <-r.closed:
+		return 0, fmt.Errorf("%w: reader closed", message.ErrStreamCanceled)
+	default:
+	}
+	for len(r.buf) == 0 {
+		select {
+		case b := <-r.ch:
+			r.buf = b
+		case <-r.done:
+			// chunks queued before the end are still delivered
+			select {
+			case b := <-r.ch:
+				r.buf = b
+				continue
+			default:
+			}
+			if r.err != nil {
+				return 0, r.err
+			}
+			return 0, io.EOF
+		case <-r.closed:
+			return 0, fmt.Errorf("%w: reader closed", message.ErrStreamCanceled)
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close releases the reader, canceling the stream if it has not ended.
+func (r *Reader) Close() error {
+	r.once.Do(func() {
+		close(r.closed)
+		r.onClose()
+	})
+	return nil
+}
+
+// push queues a chunk, blocking while the queue is full. The chunk is
+// discarded if the reader is closed or the stream finishes meanwhile, for
+// example because the connection closed.
+func (r *Reader) push(b []byte) {
+	select {
+	case r.ch <- b:
+	case <-r.closed:
+	case <-r.done:
+	}
+}
+
+// finish ends the stream. err is nil for a normal end. Only the first call
+// has an effect.
+func (r *Reader) finish(err error) {
+	r.finishOnce.Do(func() {
+		r.err = err
+		close(r.done)
+	})
+}
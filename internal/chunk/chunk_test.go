@@ -0,0 +1,259 @@
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// cancelLog records the cancel frames a Demux writes.
+type cancelLog struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (l *cancelLog) cancel(id, reason, dest string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, id+"|"+reason+"|"+dest)
+}
+
+func (l *cancelLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.calls...)
+}
+
+// readResult reads r to the end in the background.
+func readResult(r io.Reader) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, r)
+		done <- err
+	}()
+	return done
+}
+
+func wait(t *testing.T, ch <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out")
+		return nil
+	}
+}
+
+func TestEnd(t *testing.T) {
+	var log cancelLog
+	d := NewDemux(log.cancel)
+	r := d.Handle(Frame{ID: "s", Seq: 0, Data: []byte("ab")})
+	if r == nil {
+		t.Fatal("first frame did not open a stream")
+	}
+	if d.Handle(Frame{ID: "s", Seq: 1, Data: []byte("cd"), End: true}) != nil {
+		t.Fatal("later frame opened a stream")
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || string(got) != "abcd" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+	r.Close()
+	if calls := log.get(); len(calls) != 0 {
+		t.Fatalf("cancel after normal end: %v", calls)
+	}
+}
+
+func TestCancelByReceiver(t *testing.T) {
+	var log cancelLog
+	d := NewDemux(log.cancel)
+	r := d.Handle(Frame{ID: "s", Sender: "peer", Data: []byte("ab")})
+	r.Close()
+	r.Close()
+	if calls := log.get(); len(calls) != 1 || calls[0] != "s|closed by receiver|peer" {
+		t.Fatalf("cancel calls %v", calls)
+	}
+	if _, err := r.Read(make([]byte, 4)); !errors.Is(err, message.ErrStreamCanceled) {
+		t.Fatalf("read after close: %v", err)
+	}
+
+	// the tombstone swallows the rest of the stream until the sender echoes
+	// the cancel
+	for seq := int64(1); seq <= queueDepth+1; seq++ {
+		if d.Handle(Frame{ID: "s", Seq: seq, Data: []byte("x")}) != nil {
+			t.Fatal("late frame opened a stream")
+		}
+	}
+	d.Handle(Frame{ID: "s", Cancel: "closed by receiver"})
+	if d.Handle(Frame{ID: "s", Seq: 5, Data: []byte("x")}) != nil {
+		t.Fatal("frame after echo opened a stream")
+	}
+	if len(d.in) != 0 {
+		t.Fatalf("stream not forgotten: %v", d.in)
+	}
+}
+
+func TestCancelBySender(t *testing.T) {
+	d := NewDemux((&cancelLog{}).cancel)
+	r := d.Handle(Frame{ID: "s", Data: []byte("ab")})
+	d.Handle(Frame{ID: "s", Cancel: "boom"})
+	got, err := io.ReadAll(r)
+	if string(got) != "ab" || !errors.Is(err, message.ErrStreamCanceled) {
+		t.Fatalf("read %q, %v", got, err)
+	}
+	if d.Handle(Frame{ID: "s", Seq: 1, Data: []byte("x")}) != nil {
+		t.Fatal("late frame opened a stream")
+	}
+}
+
+func TestCancelOutbound(t *testing.T) {
+	d := NewDemux((&cancelLog{}).cancel)
+	var (
+		mu     sync.Mutex
+		frames []*message.Message
+	)
+	started := make(chan struct{})
+	var once sync.Once
+	write := func(msg *message.Message, _ []byte) error {
+		mu.Lock()
+		frames = append(frames, msg)
+		mu.Unlock()
+		once.Do(func() { close(started) })
+		return nil
+	}
+	unblock := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		src := io.MultiReader(bytes.NewReader([]byte("a")), blockingReader(unblock))
+		msg := &message.Message{Metadata: map[string]any{message.MetadataDestination: "peer"}}
+		done <- Send(context.Background(), d, "s", 1, msg, src, write)
+	}()
+	<-started
+	d.Handle(Frame{ID: "s", Cancel: "closed by receiver"})
+	// unblock the reader so Send notices the cancel
+	close(unblock)
+	if err := wait(t, done); !errors.Is(err, message.ErrStreamCanceled) {
+		t.Fatalf("send: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	last := frames[len(frames)-1]
+	if last.Metadata[message.MetadataStreamCancel] != "closed by receiver" ||
+		last.Metadata[message.MetadataDestination] != "peer" {
+		t.Fatalf("cancel not echoed: %v", last.Metadata)
+	}
+}
+
+// blockingReader yields one byte at a time once it is closed.
+type blockingReader chan struct{}
+
+func (b blockingReader) Read(p []byte) (int, error) {
+	<-b
+	p[0] = 'x'
+	return 1, nil
+}
+
+func TestFail(t *testing.T) {
+	var log cancelLog
+	d := NewDemux(log.cancel)
+	r := d.Handle(Frame{ID: "s", Sender: "peer", Data: []byte("ab")})
+	d.Fail(Frame{ID: "s", Sender: "peer", Seq: 1}, errors.New("bad frame"))
+	if _, err := io.ReadAll(r); err == nil || err.Error() != "bad frame" {
+		t.Fatalf("read: %v", err)
+	}
+	if calls := log.get(); len(calls) != 1 || calls[0] != "s|bad frame|peer" {
+		t.Fatalf("cancel calls %v", calls)
+	}
+	if d.Handle(Frame{ID: "s", Seq: 2, Data: []byte("x")}) != nil {
+		t.Fatal("late frame opened a stream")
+	}
+	// closing the failed reader does not cancel twice
+	r.Close()
+	if calls := log.get(); len(calls) != 1 {
+		t.Fatalf("cancel calls %v", calls)
+	}
+}
+
+func TestCloseWithOpenReaders(t *testing.T) {
+	var log cancelLog
+	d := NewDemux(log.cancel)
+	idle := d.Handle(Frame{ID: "idle", Data: []byte("a")})
+
+	// fill the queue of a stream nobody reads so that the read loop blocks
+	full := d.Handle(Frame{ID: "full", Data: []byte("x")})
+	for seq := int64(1); seq < queueDepth; seq++ {
+		d.Handle(Frame{ID: "full", Seq: seq, Data: []byte("x")})
+	}
+	blocked := make(chan error, 1)
+	go func() {
+		d.Handle(Frame{ID: "full", Seq: queueDepth, Data: []byte("x")})
+		blocked <- nil
+	}()
+	select {
+	case <-blocked:
+		t.Fatal("handle did not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	o := d.Open("out")
+	closeErr := errors.New("connection closed")
+	d.Close(closeErr)
+	wait(t, blocked)
+
+	if err := wait(t, readResult(idle)); err != closeErr {
+		t.Fatalf("idle reader: %v", err)
+	}
+	if err := wait(t, readResult(full)); err != closeErr {
+		t.Fatalf("full reader: %v", err)
+	}
+	select {
+	case <-o.Done():
+	default:
+		t.Fatal("outbound stream not aborted")
+	}
+	if o := d.Open("late"); o.Err() == nil {
+		t.Fatal("open after close not aborted")
+	}
+	if d.Handle(Frame{ID: "new", Data: []byte("x")}) != nil {
+		t.Fatal("stream opened after close")
+	}
+	idle.Close()
+	if calls := log.get(); len(calls) != 0 {
+		t.Fatalf("cancel after close: %v", calls)
+	}
+}
+
+func TestCloseUnblocksReader(t *testing.T) {
+	d := NewDemux((&cancelLog{}).cancel)
+	full := d.Handle(Frame{ID: "s", Data: []byte("x")})
+	for seq := int64(1); seq < queueDepth; seq++ {
+		d.Handle(Frame{ID: "s", Seq: seq, Data: []byte("x")})
+	}
+	released := make(chan error, 1)
+	go func() {
+		d.Handle(Frame{ID: "s", Seq: queueDepth, Data: []byte("x")})
+		released <- nil
+	}()
+	time.Sleep(20 * time.Millisecond)
+	full.Close()
+	wait(t, released)
+}
+
+func TestUnknownTail(t *testing.T) {
+	var log cancelLog
+	d := NewDemux(log.cancel)
+	if d.Handle(Frame{ID: "s", Seq: 3, Data: []byte("x")}) != nil {
+		t.Fatal("tail frame opened a stream")
+	}
+	d.Fail(Frame{ID: "s", Seq: 3}, errors.New("bad"))
+	if len(log.get()) != 0 || len(d.in) != 0 {
+		t.Fatalf("tail of unknown stream tracked: %v %v", log.get(), d.in)
+	}
+}
@@ -0,0 +1,285 @@
+// Package filexfer implements resumable file transfer over sync requests.
+//
+// A transfer is an offer carrying the file name, size and SHA-256, followed
+// by data blocks and a commit. The receiver appends blocks to a partial file
+// and acknowledges each one with its new length, so a sender that reconnects
+// and offers the same file again resumes from the last acknowledged offset.
+// On commit the receiver verifies the hash and renames the partial file into
+// place.
+package filexfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// Transfer steps carried in message.MetadataFileAction.
+const (
+	ActionOffer  = "offer"
+	ActionData   = "data"
+	ActionCommit = "commit"
+)
+
+// BlockSize is the largest data block sent in a single request.
+const BlockSize = 1 << 20
+
+// Offer describes a file being transferred.
+type Offer struct {
+	Name string
+	Size int64
+	Hash string
+}
+
+// Describe hashes the file at path and returns its offer.
+func Describe(path string) (Offer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Offer{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return Offer{}, err
+	}
+	return Offer{Name: filepath.Base(path), Size: n, Hash: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// IsTransfer reports whether msg is a step of a file transfer.
+func IsTransfer(msg *message.Message) bool {
+	_, ok := msg.Metadata[message.MetadataFileAction]
+	return ok
+}
+
+func (o Offer) message(action string) *message.Message {
+	return &message.Message{Metadata: map[string]any{
+		message.MetadataFileAction: action,
+		message.MetadataFileName:   o.Name,
+		message.MetadataFileSize:   o.Size,
+		message.MetadataFileHash:   o.Hash,
+	}}
+}
+
+func parseOffer(msg *message.Message) (Offer, error) {
+	var o Offer
+	o.Name, _ = msg.Metadata[message.MetadataFileName].(string)
+	o.Hash, _ = msg.Metadata[message.MetadataFileHash].(string)
+	size, ok := toInt64(msg.Metadata[message.MetadataFileSize])
+	if !ok || size < 0 {
+		return o, errors.New("invalid file size")
+	}
+	o.Size = size
+	if o.Name == "" || o.Name == "." || o.Name == ".." || strings.ContainsAny(o.Name, `/\`) {
+		return o, fmt.Errorf("invalid file name %q", o.Name)
+	}
+	if b, err := hex.DecodeString(o.Hash); err != nil || len(b) != sha256.Size {
+		return o, errors.New("invalid file hash")
+	}
+	return o, nil
+}
+
+// CallFunc performs a sync request and returns the response.
+type CallFunc func(ctx context.Context, msg *message.Message, data []byte) (*message.Message, []byte, error)
+
+// Send transfers the file at path using call, resuming from the offset the
+// receiver acknowledges.
+func Send(ctx context.Context, path string, call CallFunc) error {
+	o, err := Describe(path)
+	if err != nil {
+		return err
+	}
+	offset, err := step(ctx, call, o.message(ActionOffer), nil)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	buf := make([]byte, BlockSize)
+	for offset < o.Size {
+		n, err := io.ReadFull(f, buf[:min(int64(len(buf)), o.Size-offset)])
+		if err != nil {
+			return fmt.Errorf("file changed during transfer: %w", err)
+		}
+		msg := o.message(ActionData)
+		msg.Metadata[message.MetadataFileOffset] = offset
+		acked, err := step(ctx, call, msg, buf[:n])
+		if err != nil {
+			return err
+		}
+		if acked != offset+int64(n) {
+			return fmt.Errorf("receiver acknowledged offset %d, expected %d", acked, offset+int64(n))
+		}
+		offset = acked
+	}
+	_, err = step(ctx, call, o.message(ActionCommit), nil)
+	return err
+}
+
+// step sends one transfer step and returns the acknowledged offset.
+func step(ctx context.Context, call CallFunc, msg *message.Message, data []byte) (int64, error) {
+	resp, _, err := call(ctx, msg, data)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Status == message.StatusFailure {
+		reason, _ := resp.Metadata[message.MetadataError].(string)
+		if reason == message.ErrFileNotAccepted.Error() {
+			return 0, message.ErrFileNotAccepted
+		}
+		return 0, errors.New(reason)
+	}
+	offset, _ := toInt64(resp.Metadata[message.MetadataFileOffset])
+	return offset, nil
+}
+
+// Receiver stores transferred files in a directory.
+type Receiver struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewReceiver returns a Receiver writing into dir.
+func NewReceiver(dir string) *Receiver {
+	return &Receiver{dir: dir}
+}
+
+// Handle processes one transfer step and returns the reply to send. When a
+// commit completes, path is the location of the verified file.
+func (r *Receiver) Handle(msg *message.Message, data []byte) (reply *message.Message, path string) {
+	offset, path, err := r.handle(msg, data)
+	reply = &message.Message{SyncResponse: true, ConversationGUID: msg.ConversationGUID}
+	if err != nil {
+		reply.Status = message.StatusFailure
+		reply.Metadata = map[string]any{message.MetadataError: err.Error()}
+		return reply, ""
+	}
+	reply.Metadata = map[string]any{message.MetadataFileOffset: offset}
+	return reply, path
+}
+
+// Reject returns the reply for a transfer step when files are not accepted.
+func Reject(msg *message.Message) *message.Message {
+	return &message.Message{
+		Status:           message.StatusFailure,
+		SyncResponse:     true,
+		ConversationGUID: msg.ConversationGUID,
+		Metadata:         map[string]any{message.MetadataError: message.ErrFileNotAccepted.Error()},
+	}
+}
+
+func (r *Receiver) handle(msg *message.Message, data []byte) (int64, string, error) {
+	o, err := parseOffer(msg)
+	if err != nil {
+		return 0, "", err
+	}
+	part := filepath.Join(r.dir, "."+o.Name+"."+o.Hash[:16]+".part")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	size, err := partSize(part)
+	if err != nil {
+		return 0, "", err
+	}
+	switch action, _ := msg.Metadata[message.MetadataFileAction].(string); action {
+	case ActionOffer:
+		if size > o.Size {
+			if err := os.Truncate(part, 0); err != nil {
+				return 0, "", err
+			}
+			size = 0
+		}
+		return size, "", nil
+	case ActionData:
+		offset, ok := toInt64(msg.Metadata[message.MetadataFileOffset])
+		if !ok || offset != size {
+			return size, "", fmt.Errorf("unexpected offset %d, have %d bytes", offset, size)
+		}
+		if size+int64(len(data)) > o.Size {
+			return size, "", errors.New("data exceeds file size")
+		}
+		f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return size, "", err
+		}
+		n, err := f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return size + int64(n), "", err
+	case ActionCommit:
+		if size != o.Size {
+			return size, "", fmt.Errorf("incomplete file: have %d of %d bytes", size, o.Size)
+		}
+		if o.Size == 0 {
+			if err := os.WriteFile(part, nil, 0o600); err != nil {
+				return 0, "", err
+			}
+		}
+		sum, err := hashFile(part)
+		if err != nil {
+			return size, "", err
+		}
+		if sum != o.Hash {
+			os.Remove(part)
+			return 0, "", errors.New("sha-256 mismatch")
+		}
+		dst := filepath.Join(r.dir, o.Name)
+		if err := os.Rename(part, dst); err != nil {
+			return size, "", err
+		}
+		return size, dst, nil
+	default:
+		return 0, "", fmt.Errorf("unknown file action %q", action)
+	}
+}
+
+func partSize(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func toInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
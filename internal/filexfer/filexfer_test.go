@@ -0,0 +1,51 @@
+package filexfer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+func writeFile(t *testing.T, dir string, size int) (string, []byte) {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	path := filepath.Join(dir, "data.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func TestCommitVerifiesHash(t *testing.T) {
+	src, _ := writeFile(t, t.TempDir(), 1000)
+	dst := t.TempDir()
+	r := NewReceiver(dst)
+	corrupt := func(ctx context.Context, msg *message.Message, data []byte) (*message.Message, []byte, error) {
+		if len(data) > 0 {
+			data = append([]byte{^data[0]}, data[1:]...)
+		}
+		reply, _ := r.Handle(msg, data)
+		return reply, nil, nil
+	}
+	if err := Send(context.Background(), src, corrupt); err == nil {
+		t.Fatalf("expected checksum failure")
+	}
+	if entries, _ := os.ReadDir(dst); len(entries) != 0 {
+		t.Fatalf("corrupt data kept: %v", entries)
+	}
+}
+
+func TestRejectsUnsafeNames(t *testing.T) {
+	r := NewReceiver(t.TempDir())
+	o := Offer{Name: "../escape", Size: 1, Hash: string(bytes.Repeat([]byte("0"), 64))}
+	reply, _ := r.Handle(o.message(ActionOffer), nil)
+	if reply.Status != message.StatusFailure {
+		t.Fatalf("unsafe name accepted")
+	}
+}
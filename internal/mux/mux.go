@@ -0,0 +1,122 @@
+// Package mux schedules frames from several logical channels onto a single
+// connection. Each channel is a FIFO queue and a dedicated goroutine writes
+// one frame at a time, visiting channels with pending frames in round-robin
+// order, so a bulk transfer split into fragments cannot starve small
+// messages queued on other channels.
+package mux
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrClosed is returned for frames queued after the writer was closed.
+var ErrClosed = errors.New("mux: writer closed")
+
+type job struct {
+	bufs [][]byte
+	done chan error
+}
+
+// Writer multiplexes frames onto w.
+type Writer struct {
+	w io.Writer
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queues map[string][]*job
+	ring   []string
+	err    error
+}
+
+// New creates a Writer and starts its write goroutine.
+func New(w io.Writer) *Writer {
+	m := &Writer{w: w, queues: make(map[string][]*job)}
+	m.cond = sync.NewCond(&m.mu)
+	go m.run()
+	return m
+}
+
+// Write queues a frame made of bufs on channel and waits until it has been
+// written. The buffers must not be modified until Write returns.
+func (m *Writer) Write(channel string, bufs ...[]byte) error {
+	j := &job{bufs: bufs, done: make(chan error, 1)}
+	m.mu.Lock()
+	if m.err != nil {
+		err := m.err
+		m.mu.Unlock()
+		return err
+	}
+	if len(m.queues[channel]) == 0 {
+		m.ring = append(m.ring, channel)
+	}
+	m.queues[channel] = append(m.queues[channel], j)
+	m.cond.Signal()
+	m.mu.Unlock()
+	return <-j.done
+}
+
+// Close stops the writer. Pending and future frames fail with err, or
+// ErrClosed if err is nil.
+func (m *Writer) Close(err error) {
+	if err == nil {
+		err = ErrClosed
+	}
+	m.mu.Lock()
+	if m.err == nil {
+		m.err = err
+	}
+	m.cond.Signal()
+	m.mu.Unlock()
+}
+
+func (m *Writer) run() {
+	for {
+		m.mu.Lock()
+		for len(m.ring) == 0 && m.err == nil {
+			m.cond.Wait()
+		}
+		if m.err != nil {
+			m.failAll()
+			m.mu.Unlock()
+			return
+		}
+		ch := m.ring[0]
+		m.ring = m.ring[1:]
+		q := m.queues[ch]
+		j := q[0]
+		if len(q) == 1 {
+			delete(m.queues, ch)
+		} else {
+			m.queues[ch] = q[1:]
+			m.ring = append(m.ring, ch)
+		}
+		m.mu.Unlock()
+
+		var err error
+		for _, b := range j.bufs {
+			if len(b) == 0 {
+				continue
+			}
+			if _, err = m.w.Write(b); err != nil {
+				break
+			}
+		}
+		j.done <- err
+		if err != nil {
+			m.Close(err)
+		}
+	}
+}
+
+// failAll fails every queued frame. The caller must hold m.mu.
+func (m *Writer) failAll() {
+	for ch, q := range m.queues {
+		for _, j := range q {
+			j.done <- m.err
+		}
+		delete(m.queues, ch)
+	}
+	m.ring = nil
+}
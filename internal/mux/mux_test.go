@@ -0,0 +1,83 @@
+package mux
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedWriter blocks its first write until released so that frames from
+// several channels can queue up behind it.
+type gatedWriter struct {
+	entered chan struct{}
+	gate    chan struct{}
+	once    sync.Once
+	out     []string
+}
+
+func (g *gatedWriter) Write(p []byte) (int, error) {
+	g.once.Do(func() {
+		close(g.entered)
+		<-g.gate
+	})
+	g.out = append(g.out, string(p))
+	return len(p), nil
+}
+
+func (m *Writer) queued(ch string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.queues[ch])
+}
+
+// waitFor polls cond until it holds, failing the test after two seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	g := &gatedWriter{entered: make(chan struct{}), gate: make(chan struct{})}
+	m := New(g)
+	defer m.Close(nil)
+
+	var wg sync.WaitGroup
+	write := func(ch, frame string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.Write(ch, []byte(frame)); err != nil {
+				t.Errorf("write %s: %v", frame, err)
+			}
+		}()
+	}
+	write("bulk", "b0")
+	<-g.entered
+	write("bulk", "b1")
+	waitFor(t, func() bool { return m.queued("bulk") >= 1 })
+	write("bulk", "b2")
+	waitFor(t, func() bool { return m.queued("bulk") >= 2 })
+	write("ctl", "s0")
+	waitFor(t, func() bool { return m.queued("ctl") >= 1 })
+	close(g.gate)
+	wg.Wait()
+
+	if got := strings.Join(g.out, ","); got != "b0,b1,s0,b2" {
+		t.Fatalf("unexpected write order %s", got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	m := New(&gatedWriter{entered: make(chan struct{}), gate: make(chan struct{})})
+	m.Close(nil)
+	if err := m.Write("a", []byte("x")); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+// Package proxyproto reads HAProxy PROXY protocol v1 and v2 headers so that
+// servers behind a load balancer see the real client address.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds reading the header when no timeout is configured.
+const DefaultTimeout = 5 * time.Second
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// ErrUntrusted is returned when a connection from a source that is not a
+// trusted proxy begins with a PROXY header.
+var ErrUntrusted = errors.New("PROXY header from untrusted source")
+
+// ErrMissing is returned when a trusted proxy does not send a header.
+var ErrMissing = errors.New("missing PROXY header")
+
+// Listener wraps accepted connections in Conn.
+type Listener struct {
+	net.Listener
+	trusted []netip.Prefix
+	timeout time.Duration
+}
+
+// NewListener returns a listener that expects PROXY headers from sources in
+// trusted and rejects them from anywhere else. A zero timeout uses
+// DefaultTimeout.
+func NewListener(ln net.Listener, trusted []netip.Prefix, timeout time.Duration) *Listener {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Listener{Listener: ln, trusted: trusted, timeout: timeout}
+}
+
+// Accept returns the next connection without reading from it; the header is
+// read on first use.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, br: bufio.NewReader(conn), trusted: l.isTrusted(conn.RemoteAddr()), timeout: l.timeout}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := ap.Addr().Unmap()
+	for _, p := range l.trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection whose remote address comes from its PROXY header.
+type Conn struct {
+	net.Conn
+	br      *bufio.Reader
+	trusted bool
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn { return c.Conn }
+
+// Err reads the header of a connection from a trusted proxy, if it has not
+// been read yet, and reports whether it was valid. Connections from other
+// sources are checked lazily on the first Read, since many peers send
+// nothing until the server has spoken.
+func (c *Conn) Err() error {
+	if c.trusted {
+		c.once.Do(c.readHeader)
+	}
+	return c.err
+}
+
+// RemoteAddr returns the client address announced by a trusted proxy, or the
+// address of the peer otherwise.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.Err() == nil && c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// Read reads data following the header.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.once.Do(func() {
+		if c.trusted {
+			c.readHeader()
+		} else {
+			c.rejectHeader()
+		}
+	})
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(p)
+}
+
+func (c *Conn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+	c.remote, c.err = Read(c.br)
+}
+
+// rejectHeader fails connections from untrusted sources that start with a
+// PROXY header. Five bytes distinguish both versions from WatsonTcp and TLS
+// traffic.
+func (c *Conn) rejectHeader() {
+	// a read error is not kept by the buffered reader and surfaces on the
+	// read that follows
+	b, err := c.br.Peek(5)
+	if err != nil {
+		return
+	}
+	if bytes.Equal(b, v1Prefix[:5]) || bytes.Equal(b, v2Signature[:5]) {
+		c.err = ErrUntrusted
+	}
+}
+
+// Read parses a v1 or v2 header from r and returns the source address it
+// carries. The address is nil for LOCAL and UNKNOWN headers and for address
+// families other than TCP over IPv4 or IPv6, in which case the connection's
+// own address applies.
+func Read(r *bufio.Reader) (net.Addr, error) {
+	b, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, ErrMissing
+	}
+	if bytes.Equal(b, v1Prefix) {
+		return readV1(r)
+	}
+	b, err = r.Peek(len(v2Signature))
+	if err != nil || !bytes.Equal(b, v2Signature) {
+		return nil, ErrMissing
+	}
+	return readV2(r)
+}
+
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	// the longest valid v1 header is 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("invalid PROXY v1 header")
+	}
+	fields := strings.Split(s, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("invalid PROXY v1 header")
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil || ip.Is4() != (fields[1] == "TCP4") {
+		return nil, errors.New("invalid PROXY v1 source address")
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errors.New("invalid PROXY v1 source port")
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, errors.New("unsupported PROXY v2 version")
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	switch cmd := hdr[12] & 0x0f; cmd {
+	case 0: // LOCAL: health checks from the proxy itself
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", cmd)
+	}
+	var ip netip.Addr
+	var port uint16
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("short PROXY v2 address block")
+		}
+		ip = netip.AddrFrom4([4]byte(body[0:4]))
+		port = binary.BigEndian.Uint16(body[8:10])
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short PROXY v2 address block")
+		}
+		ip = netip.AddrFrom16([16]byte(body[0:16]))
+		port = binary.BigEndian.Uint16(body[32:34])
+	default:
+		return nil, nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+}
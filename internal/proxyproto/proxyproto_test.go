@@ -0,0 +1,144 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/internal/transport"
+)
+
+func v2Header(cmd, fam byte, addr []byte) []byte {
+	b := append([]byte{}, v2Signature...)
+	b = append(b, 0x20|cmd, fam)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(addr)))
+	return append(b, addr...)
+}
+
+func TestRead(t *testing.T) {
+	v4 := []byte{203, 0, 113, 7, 10, 0, 0, 1, 0x1f, 0x90, 0x23, 0x28}
+	v6 := make([]byte, 36)
+	copy(v6, netip.MustParseAddr("2001:db8::1").AsSlice())
+	binary.BigEndian.PutUint16(v6[32:], 443)
+	cases := []struct {
+		name string
+		in   string
+		want string
+		err  bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 203.0.113.7 10.0.0.1 5555 9000\r\n", "203.0.113.7:5555", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 443 9000\r\n", "[2001:db8::1]:443", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "", false},
+		{"v1 bad family", "PROXY TCP4 2001:db8::1 10.0.0.1 1 2\r\n", "", true},
+		{"v1 no crlf", "PROXY TCP4 203.0.113.7 10.0.0.1 5555 9000\n", "", true},
+		{"v2 tcp4", string(v2Header(1, 0x11, v4)), "203.0.113.7:8080", false},
+		{"v2 tcp6", string(v2Header(1, 0x21, v6)), "[2001:db8::1]:443", false},
+		{"v2 local", string(v2Header(0, 0x00, nil)), "", false},
+		{"v2 short", string(v2Header(1, 0x11, v4[:4])), "", true},
+		{"missing", "{\"len\":0}\r\n\r\n", "", true},
+	}
+	for _, tc := range cases {
+		addr, err := Read(bufio.NewReader(strings.NewReader(tc.in + "rest")))
+		if (err != nil) != tc.err {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func listen(t *testing.T, trusted string) *Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	prefixes, err := transport.ParsePrefixes([]string{trusted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewListener(ln, prefixes, 200*time.Millisecond)
+}
+
+func exchange(t *testing.T, l *Listener, send string) *Conn {
+	go func() {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		c.Write([]byte(send))
+		time.Sleep(500 * time.Millisecond)
+		c.Close()
+	}()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.(*Conn)
+}
+
+func TestTrustedProxy(t *testing.T) {
+	l := listen(t, "127.0.0.1")
+	c := exchange(t, l, "PROXY TCP4 198.51.100.9 127.0.0.1 4000 9000\r\nhello")
+	if err := c.Err(); err != nil {
+		t.Fatalf("header rejected: %v", err)
+	}
+	if got := c.RemoteAddr().String(); got != "198.51.100.9:4000" {
+		t.Fatalf("unexpected remote address %s", got)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("data after header: %q %v", buf, err)
+	}
+
+	c = exchange(t, l, "")
+	if err := c.Err(); !errors.Is(err, ErrMissing) {
+		t.Fatalf("expected missing header error, got %v", err)
+	}
+}
+
+func TestUntrustedSource(t *testing.T) {
+	l := listen(t, "10.0.0.0/8")
+	c := exchange(t, l, "PROXY TCP4 198.51.100.9 127.0.0.1 4000 9000\r\nhello")
+	if err := c.Err(); err != nil {
+		t.Fatalf("untrusted connection checked before its first read: %v", err)
+	}
+	if !strings.HasPrefix(c.RemoteAddr().String(), "127.0.0.1:") {
+		t.Fatalf("untrusted connection should keep its own address, got %s", c.RemoteAddr())
+	}
+	if _, err := c.Read(make([]byte, 5)); !errors.Is(err, ErrUntrusted) {
+		t.Fatalf("expected ErrUntrusted, got %v", err)
+	}
+
+	c = exchange(t, l, "{\"len\":0}\r\n\r\n")
+	if err := c.Err(); err != nil {
+		t.Fatalf("plain traffic rejected: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "{\"le" {
+		t.Fatalf("plain traffic from untrusted source: %q %v", buf, err)
+	}
+
+	// a silent peer is admitted without waiting for its first bytes
+	c = exchange(t, l, "")
+	start := time.Now()
+	if err := c.Err(); err != nil || time.Since(start) > 100*time.Millisecond {
+		t.Fatalf("silent peer: %v after %s", err, time.Since(start))
+	}
+	if _, err := c.Read(buf); err != io.EOF {
+		t.Fatalf("expected EOF from closed peer, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	cases := map[string][2]string{
+		"127.0.0.1:9000":       {"tcp", "127.0.0.1:9000"},
+		"unix:///run/app.sock": {"unix", "/run/app.sock"},
+		"unix://relative.sock": {"unix", "relative.sock"},
+		"[::1]:9000":           {"tcp", "[::1]:9000"},
+	}
+	for in, want := range cases {
+		network, addr := Split(in)
+		if network != want[0] || addr != want[1] {
+			t.Errorf("Split(%q) = %s %s, want %s %s", in, network, addr, want[0], want[1])
+		}
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	addr := UnixPrefix + path
+
+	// leave a socket file behind without a listener
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := Listen(addr, 0o600)
+	if err != nil {
+		t.Fatalf("stale socket not cleaned up: %v", err)
+	}
+	defer ln.Close()
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected socket mode: %v %v", fi.Mode(), err)
+	}
+
+	if _, err := Listen(addr, 0); err == nil {
+		t.Fatalf("listened on a socket that is in use")
+	}
+
+	file := filepath.Join(t.TempDir(), "plain")
+	os.WriteFile(file, nil, 0o600)
+	if _, err := Listen(UnixPrefix+file, 0); err == nil {
+		t.Fatalf("replaced a regular file")
+	}
+}
@@ -0,0 +1,160 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/WasimAhmad/watsontcp-go/client"
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// Client issues JSON-RPC calls over a WatsonTcp client connection.
+type Client struct {
+	c      *client.Client
+	nextID atomic.Int64
+}
+
+// NewClient returns a Client that sends calls through c. Request ids are
+// only unique per Client, so share one Client per connection.
+func NewClient(c *client.Client) *Client {
+	return &Client{c: c}
+}
+
+// Call invokes method with params and decodes the result into result, which
+// may be nil. Errors returned by the server are reported as *Error.
+func (c *Client) Call(ctx context.Context, method string, params any, result any) error {
+	req, err := c.newRequest(method, params, false)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, respData, err := c.c.SendSync(ctx, frame(string(req.ID)), data)
+	if err != nil {
+		return err
+	}
+	var resp Response
+	if err := json.Unmarshal(respData, &resp); err != nil {
+		return fmt.Errorf("jsonrpc: decode response: %w", err)
+	}
+	return resp.decode(result)
+}
+
+// Notify sends a notification, which the server never answers.
+func (c *Client) Notify(method string, params any) error {
+	req, err := c.newRequest(method, params, true)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return c.c.Send(frame(""), data)
+}
+
+// BatchCall is one element of a batch. After Batch returns, Error holds the
+// outcome of the call and Result has been decoded for successful calls.
+type BatchCall struct {
+	Method string
+	Params any
+	Result any
+
+	// Notify sends the element as a notification.
+	Notify bool
+
+	Error error
+}
+
+// Batch sends calls in a single frame. Per-call errors are recorded in each
+// BatchCall; the returned error reports transport or decoding failures.
+func (c *Client) Batch(ctx context.Context, calls []*BatchCall) error {
+	if len(calls) == 0 {
+		return errors.New("jsonrpc: empty batch")
+	}
+	reqs := make([]*Request, len(calls))
+	byID := make(map[string]*BatchCall)
+	for i, bc := range calls {
+		req, err := c.newRequest(bc.Method, bc.Params, bc.Notify)
+		if err != nil {
+			return err
+		}
+		reqs[i] = req
+		if !bc.Notify {
+			byID[string(req.ID)] = bc
+		}
+	}
+	data, err := json.Marshal(reqs)
+	if err != nil {
+		return err
+	}
+	if len(byID) == 0 {
+		return c.c.Send(frame(""), data)
+	}
+	_, respData, err := c.c.SendSync(ctx, frame(""), data)
+	if err != nil {
+		return err
+	}
+	// an empty response means the server had nothing to answer
+	var resps []Response
+	if len(respData) > 0 {
+		if err := json.Unmarshal(respData, &resps); err != nil {
+			var single Response
+			if json.Unmarshal(respData, &single) == nil && single.Error != nil {
+				return single.Error
+			}
+			return fmt.Errorf("jsonrpc: decode batch response: %w", err)
+		}
+	}
+	for _, resp := range resps {
+		if bc := byID[string(resp.ID)]; bc != nil {
+			bc.Error = resp.decode(bc.Result)
+			delete(byID, string(resp.ID))
+		}
+	}
+	for _, bc := range byID {
+		bc.Error = errors.New("jsonrpc: no response")
+	}
+	return nil
+}
+
+func (c *Client) newRequest(method string, params any, notify bool) (*Request, error) {
+	req := &Request{JSONRPC: Version, Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		req.Params = raw
+	}
+	if !notify {
+		req.ID = json.RawMessage(strconv.FormatInt(c.nextID.Add(1), 10))
+	}
+	return req, nil
+}
+
+func (r *Response) decode(result any) error {
+	if r.Error != nil {
+		return r.Error
+	}
+	if result == nil || r.Result == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Result, result)
+}
+
+// frame builds the WatsonTcp message carrying a JSON-RPC payload. guid is
+// the request id for single calls and empty otherwise.
+func frame(guid string) *message.Message {
+	return &message.Message{
+		Status:           message.StatusNormal,
+		Metadata:         map[string]any{message.MetadataContentType: ContentType},
+		ConversationGUID: guid,
+	}
+}
@@ -0,0 +1,78 @@
+// Package jsonrpc carries JSON-RPC 2.0 over WatsonTcp framing.
+//
+// Each frame payload is a JSON-RPC request, notification, response or batch.
+// Requests are sent as sync requests whose ConversationGUID is the raw JSON
+// text of the request id, so the id travels both in the payload and in the
+// frame header. Notifications are sent as ordinary messages and never
+// answered. A batch is carried in a single frame; it is sent as a sync
+// request when it contains at least one request.
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Version is the protocol version carried in every object.
+const Version = "2.0"
+
+// ContentType is recorded under message.MetadataContentType on frames sent
+// by this package.
+const ContentType = "application/json-rpc"
+
+// Standard error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+
+	// CodeServerError is used for method errors that are not an *Error.
+	CodeServerError = -32000
+)
+
+// Request is a JSON-RPC request or, when ID is nil, a notification.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// IsNotification reports whether the request carries no id.
+func (r *Request) IsNotification() bool { return r.ID == nil }
+
+// Response is a JSON-RPC response. Exactly one of Result and Error is set.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Error is a JSON-RPC error object. Methods may return an *Error to control
+// the code reported to the caller.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc: %d %s", e.Code, e.Message)
+}
+
+// NewError returns an *Error with the given code and message.
+func NewError(code int, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+var nullID = json.RawMessage("null")
+
+// isBatch reports whether data holds a JSON array.
+func isBatch(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return len(data) > 0 && data[0] == '['
+}
@@ -0,0 +1,215 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/client"
+	"github.com/WasimAhmad/watsontcp-go/jsonrpc"
+	"github.com/WasimAhmad/watsontcp-go/message"
+	"github.com/WasimAhmad/watsontcp-go/server"
+)
+
+func newServer(notified chan<- string) *jsonrpc.Server {
+	s := jsonrpc.NewServer()
+	s.Register("subtract", func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p []int
+		if err := json.Unmarshal(params, &p); err != nil || len(p) != 2 {
+			return nil, jsonrpc.NewError(jsonrpc.CodeInvalidParams, "expected two numbers")
+		}
+		return p[0] - p[1], nil
+	})
+	s.Register("update", func(ctx context.Context, params json.RawMessage) (any, error) {
+		if notified != nil {
+			notified <- string(params)
+		}
+		return nil, nil
+	})
+	return s
+}
+
+func TestHandle(t *testing.T) {
+	s := newServer(nil)
+	ctx := context.Background()
+	cases := map[string]string{
+		`{"jsonrpc":"2.0","method":"subtract","params":[42,23],"id":1}`: `{"jsonrpc":"2.0","result":19,"id":1}`,
+		`{"jsonrpc":"2.0","method":"foobar","id":"1"}`:                  `{"jsonrpc":"2.0","error":{"code":-32601,"message":"method \"foobar\" not found"},"id":"1"}`,
+		`{"jsonrpc":"2.0","method":"subtract","params":"x","id":2}`:     `{"jsonrpc":"2.0","error":{"code":-32602,"message":"expected two numbers"},"id":2}`,
+		`{"jsonrpc":"2.0","method"`:                                     `{"jsonrpc":"2.0","error":{"code":-32700,"message":"unexpected end of JSON input"},"id":null}`,
+		`[]`:                                                            `{"jsonrpc":"2.0","error":{"code":-32600,"message":"empty batch"},"id":null}`,
+		`{"jsonrpc":"2.0","method":"update","params":[1]}`:              ``,
+		`[{"jsonrpc":"2.0","method":"subtract","params":[1,1],"id":1},{"jsonrpc":"2.0","method":"update"},{"foo":"boo"}]`: `[{"jsonrpc":"2.0","result":0,"id":1},{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}]`,
+	}
+	for in, want := range cases {
+		if got := string(s.Handle(ctx, []byte(in))); got != want {
+			t.Fatalf("%s:\n got %s\nwant %s", in, got, want)
+		}
+	}
+}
+
+func TestClientServer(t *testing.T) {
+	notified := make(chan string, 1)
+	rs := newServer(notified)
+	var srv *server.Server
+	srv = server.New("127.0.0.1:30180", nil, server.Callbacks{
+		OnMessage: rs.OnServerMessage(func(id string, msg *message.Message, data []byte) error {
+			return srv.Send(id, msg, data)
+		}),
+	}, nil)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cli := client.New("127.0.0.1:30180", nil, client.Callbacks{}, nil)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+	rc := jsonrpc.NewClient(cli)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var diff int
+	if err := rc.Call(ctx, "subtract", []int{5, 3}, &diff); err != nil || diff != 2 {
+		t.Fatalf("call: %v %d", err, diff)
+	}
+	var rerr *jsonrpc.Error
+	if err := rc.Call(ctx, "missing", nil, nil); !errors.As(err, &rerr) || rerr.Code != jsonrpc.CodeMethodNotFound {
+		t.Fatalf("expected method not found, got %v", err)
+	}
+
+	if err := rc.Notify("update", []int{7}); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	select {
+	case got := <-notified:
+		if got != "[7]" {
+			t.Fatalf("unexpected params %s", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("notification not received")
+	}
+
+	var a, b int
+	calls := []*jsonrpc.BatchCall{
+		{Method: "subtract", Params: []int{10, 1}, Result: &a},
+		{Method: "subtract", Params: "bad", Result: &b},
+		{Method: "update", Params: []int{1}, Notify: true},
+	}
+	if err := rc.Batch(ctx, calls); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	<-notified
+	if calls[0].Error != nil || a != 9 {
+		t.Fatalf("batch call 0: %v %d", calls[0].Error, a)
+	}
+	if !errors.As(calls[1].Error, &rerr) || rerr.Code != jsonrpc.CodeInvalidParams {
+		t.Fatalf("batch call 1: %v", calls[1].Error)
+	}
+}
+
+func TestNotificationOnlySyncFrame(t *testing.T) {
+	notified := make(chan string, 2)
+	rs := newServer(notified)
+	sendErrs := make(chan error, 1)
+	rs.OnError = func(id string, err error) { sendErrs <- err }
+	var srv *server.Server
+	var failSend atomic.Bool
+	srv = server.New("127.0.0.1:30181", nil, server.Callbacks{
+		OnMessage: rs.OnServerMessage(func(id string, msg *message.Message, data []byte) error {
+			if failSend.Load() {
+				return errors.New("send failed")
+			}
+			return srv.Send(id, msg, data)
+		}),
+	}, nil)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cli := client.New("127.0.0.1:30181", nil, client.Callbacks{}, nil)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+
+	// a peer that sends notifications as a sync request still gets a reply
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	batch := []byte(`[{"jsonrpc":"2.0","method":"update","params":[1]},{"jsonrpc":"2.0","method":"update","params":[2]}]`)
+	_, data, err := cli.SendSync(ctx, &message.Message{}, batch)
+	if err != nil || len(data) != 0 {
+		t.Fatalf("expected an empty response, got %q %v", data, err)
+	}
+	<-notified
+	<-notified
+
+	failSend.Store(true)
+	if err := cli.Send(&message.Message{SyncRequest: true, ConversationGUID: "1"}, []byte(`{"jsonrpc":"2.0","method":"subtract","params":[2,1],"id":1}`)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	select {
+	case err := <-sendErrs:
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("send failure not reported")
+	}
+}
+
+func TestSlowMethod(t *testing.T) {
+	rs := newServer(nil)
+	release := make(chan struct{})
+	rs.Register("slow", func(ctx context.Context, params json.RawMessage) (any, error) {
+		<-release
+		return "done", nil
+	})
+	var srv *server.Server
+	srv = server.New("127.0.0.1:30182", nil, server.Callbacks{
+		OnMessage: rs.OnServerMessage(func(id string, msg *message.Message, data []byte) error {
+			return srv.Send(id, msg, data)
+		}),
+	}, nil)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cli := client.New("127.0.0.1:30182", nil, client.Callbacks{}, nil)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+	rc := jsonrpc.NewClient(cli)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	slow := make(chan error, 1)
+	go func() {
+		var res string
+		err := rc.Call(ctx, "slow", nil, &res)
+		if err == nil && res != "done" {
+			err = errors.New("unexpected result " + res)
+		}
+		slow <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// a second call completes while the first is still running
+	var diff int
+	if err := rc.Call(ctx, "subtract", []int{5, 3}, &diff); err != nil || diff != 2 {
+		t.Fatalf("call behind a slow method: %v %d", err, diff)
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatalf("slow call: %v", err)
+	}
+}
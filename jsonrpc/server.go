@@ -0,0 +1,173 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// Method handles a call. params is the raw params member and may be nil.
+// The result is encoded with encoding/json.
+type Method func(ctx context.Context, params json.RawMessage) (any, error)
+
+type clientKey struct{}
+
+// ClientID returns the id of the client whose call is handled with ctx.
+func ClientID(ctx context.Context) string {
+	id, _ := ctx.Value(clientKey{}).(string)
+	return id
+}
+
+// Server dispatches JSON-RPC calls to registered methods.
+type Server struct {
+	// OnError, if set, is called when a response cannot be sent to client
+	// id. It has the signature of server.Callbacks.OnError.
+	OnError func(id string, err error)
+
+	mu      sync.RWMutex
+	methods map[string]Method
+}
+
+// NewServer creates an empty Server.
+func NewServer() *Server {
+	return &Server{methods: make(map[string]Method)}
+}
+
+// Register publishes m under name, replacing any existing method.
+func (s *Server) Register(name string, m Method) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.methods[name] = m
+}
+
+// Handle processes a request, notification or batch and returns the encoded
+// response, or nil when nothing should be sent back.
+func (s *Server) Handle(ctx context.Context, data []byte) []byte {
+	if isBatch(data) {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return encode(errorResponse(nullID, CodeParseError, err.Error()))
+		}
+		if len(raw) == 0 {
+			return encode(errorResponse(nullID, CodeInvalidRequest, "empty batch"))
+		}
+		var out []*Response
+		for _, item := range raw {
+			if resp := s.handleOne(ctx, item); resp != nil {
+				out = append(out, resp)
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		return encode(out)
+	}
+	if resp := s.handleOne(ctx, data); resp != nil {
+		return encode(resp)
+	}
+	return nil
+}
+
+func (s *Server) handleOne(ctx context.Context, data []byte) *Response {
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		var syn *json.SyntaxError
+		if errors.As(err, &syn) {
+			return errorResponse(nullID, CodeParseError, err.Error())
+		}
+		return errorResponse(nullID, CodeInvalidRequest, err.Error())
+	}
+	id := req.ID
+	if id == nil {
+		id = nullID
+	}
+	if req.JSONRPC != Version || req.Method == "" {
+		return errorResponse(id, CodeInvalidRequest, "invalid request")
+	}
+
+	s.mu.RLock()
+	m := s.methods[req.Method]
+	s.mu.RUnlock()
+	if m == nil {
+		if req.IsNotification() {
+			return nil
+		}
+		return errorResponse(id, CodeMethodNotFound, fmt.Sprintf("method %q not found", req.Method))
+	}
+
+	result, err := call(ctx, m, req.Params)
+	if req.IsNotification() {
+		return nil
+	}
+	if err != nil {
+		var rerr *Error
+		if !errors.As(err, &rerr) {
+			rerr = NewError(CodeServerError, err.Error())
+		}
+		return &Response{JSONRPC: Version, Error: rerr, ID: id}
+	}
+	enc, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(id, CodeInternalError, err.Error())
+	}
+	return &Response{JSONRPC: Version, Result: enc, ID: id}
+}
+
+// call invokes m and converts a panic into an internal error.
+func call(ctx context.Context, m Method, params json.RawMessage) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewError(CodeInternalError, fmt.Sprintf("panic: %v", r))
+		}
+	}()
+	return m(ctx, params)
+}
+
+// OnServerMessage adapts the JSON-RPC server to server.Callbacks.OnMessage.
+// Every frame is treated as JSON-RPC. Responses are written through send.
+// A sync frame holding only notifications gets an empty response, so the
+// sender is not left waiting for one. Each frame is handled on its own
+// goroutine, so a slow method does not hold up the connection and a method
+// may call back over it; frames may therefore complete out of order.
+func (s *Server) OnServerMessage(send func(id string, msg *message.Message, data []byte) error) func(id string, msg *message.Message, data []byte) {
+	return func(id string, msg *message.Message, data []byte) {
+		if msg.SyncResponse {
+			return
+		}
+		go s.serve(id, msg, data, send)
+	}
+}
+
+// serve handles one frame from client id and sends the response, if any.
+func (s *Server) serve(id string, msg *message.Message, data []byte, send func(id string, msg *message.Message, data []byte) error) {
+	ctx := context.WithValue(context.Background(), clientKey{}, id)
+	out := s.Handle(ctx, data)
+	if out == nil && !msg.SyncRequest {
+		return
+	}
+	resp := &message.Message{
+		Status:           message.StatusNormal,
+		Metadata:         map[string]any{message.MetadataContentType: ContentType},
+		SyncResponse:     msg.SyncRequest,
+		ConversationGUID: msg.ConversationGUID,
+	}
+	if err := send(id, resp, out); err != nil && s.OnError != nil {
+		s.OnError(id, fmt.Errorf("jsonrpc: send response: %w", err))
+	}
+}
+
+func errorResponse(id json.RawMessage, code int, msg string) *Response {
+	return &Response{JSONRPC: Version, Error: NewError(code, msg), ID: id}
+}
+
+func encode(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(errorResponse(nullID, CodeInternalError, err.Error()))
+	}
+	return data
+}
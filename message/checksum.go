@@ -0,0 +1,99 @@
+package message
+
+import (
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// ErrChecksumMismatch is reported when a payload does not match the checksum
+// carried in its header.
+var ErrChecksumMismatch = errors.New("payload checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// NewChecksum returns a CRC32C hash for computing Message.Checksum over a
+// payload.
+func NewChecksum() hash.Hash32 {
+	return crc32.New(castagnoli)
+}
+
+// Checksum returns the hex-encoded CRC32C of data in the form carried by
+// Message.Checksum.
+func Checksum(data []byte) string {
+	return sumString(crc32.Checksum(data, castagnoli))
+}
+
+// VerifyChecksum reports whether data matches msg.Checksum. Messages without
+// a checksum always verify.
+func VerifyChecksum(msg *Message, data []byte) bool {
+	return msg.Checksum == "" || msg.Checksum == Checksum(data)
+}
+
+// ChecksumPayload returns the checksum of p. A stream that does not implement
+// io.Seeker is read into p.Data so it can still be sent after hashing.
+func ChecksumPayload(p *Payload) (string, error) {
+	if !p.IsStream() {
+		return Checksum(p.Data), nil
+	}
+	if rs, ok := p.Stream.(io.ReadSeeker); ok {
+		start, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return "", err
+		}
+		h := NewChecksum()
+		if _, err := io.CopyN(h, rs, p.Length); err != nil {
+			return "", err
+		}
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return "", err
+		}
+		return sumString(h.Sum32()), nil
+	}
+	data := make([]byte, p.Length)
+	if _, err := io.ReadFull(p.Stream, data); err != nil {
+		return "", err
+	}
+	p.Data, p.Stream = data, nil
+	return Checksum(data), nil
+}
+
+// VerifyReader returns a reader that checksums r as it is read and, when r
+// reaches EOF, returns ErrChecksumMismatch instead of io.EOF if the data does
+// not match want. onMismatch, if not nil, is called once on a mismatch.
+func VerifyReader(r io.Reader, want string, onMismatch func()) io.Reader {
+	return &verifyReader{r: r, h: NewChecksum(), want: want, onMismatch: onMismatch}
+}
+
+type verifyReader struct {
+	r          io.Reader
+	h          hash.Hash32
+	want       string
+	onMismatch func()
+	err        error
+}
+
+func (v *verifyReader) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF && sumString(v.h.Sum32()) != v.want {
+		err = ErrChecksumMismatch
+		if v.onMismatch != nil {
+			v.onMismatch()
+		}
+	}
+	if err != nil {
+		v.err = err
+	}
+	return n, err
+}
+
+func sumString(sum uint32) string {
+	b := []byte{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,62 @@
+package message
+
+// DisconnectReason explains why a connection ended.
+type DisconnectReason int
+
+const (
+	// DisconnectNormal means the connection was closed locally or by the
+	// peer without an error or stated reason.
+	DisconnectNormal DisconnectReason = iota
+
+	// DisconnectIdleTimeout means the connection was closed because no
+	// data arrived within the idle timeout.
+	DisconnectIdleTimeout
+
+	// DisconnectRemoved means the server removed the client, for example
+	// after a ban or an IP list change.
+	DisconnectRemoved
+
+	// DisconnectShutdown means the server was stopped.
+	DisconnectShutdown
+
+	// DisconnectAuthFailure means the client failed the preshared key
+	// check.
+	DisconnectAuthFailure
+
+	// DisconnectProtocolError means the peer sent data that is not
+	// WatsonTcp framing.
+	DisconnectProtocolError
+
+	// DisconnectIOError means reading or writing the connection failed.
+	DisconnectIOError
+
+	// DisconnectHeartbeatLost means TCP keepalive probes went unanswered.
+	DisconnectHeartbeatLost
+)
+
+var disconnectReasons = [...]string{
+	DisconnectNormal:        "normal",
+	DisconnectIdleTimeout:   "idle timeout",
+	DisconnectRemoved:       "removed",
+	DisconnectShutdown:      "shutdown",
+	DisconnectAuthFailure:   "auth failure",
+	DisconnectProtocolError: "protocol error",
+	DisconnectIOError:       "i/o error",
+	DisconnectHeartbeatLost: "heartbeat lost",
+}
+
+func (r DisconnectReason) String() string {
+	if r < 0 || int(r) >= len(disconnectReasons) {
+		return "unknown"
+	}
+	return disconnectReasons[r]
+}
+
+// DisconnectReasons lists every DisconnectReason.
+func DisconnectReasons() []DisconnectReason {
+	out := make([]DisconnectReason, len(disconnectReasons))
+	for i := range out {
+		out[i] = DisconnectReason(i)
+	}
+	return out
+}
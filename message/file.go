@@ -0,0 +1,7 @@
+package message
+
+import "errors"
+
+// ErrFileNotAccepted is returned by SendFile when the peer has not called
+// ReceiveFile.
+var ErrFileNotAccepted = errors.New("file transfer not accepted")
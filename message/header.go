@@ -3,9 +3,24 @@ package message
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 )
 
+// ProtocolError reports a header that does not follow the framing, such as
+// one that is not valid JSON or declares a negative content length.
+type ProtocolError struct {
+	// Header holds the offending header without its \r\n\r\n terminator.
+	Header []byte
+	Err    error
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("protocol error: %v", e.Err)
+}
+
+func (e *ProtocolError) Unwrap() error { return e.Err }
+
 // BuildHeader serializes msg to JSON and appends \r\n\r\n.
 func BuildHeader(msg *Message) ([]byte, error) {
 	if msg == nil {
@@ -19,7 +34,8 @@ func BuildHeader(msg *Message) ([]byte, error) {
 	return data, nil
 }
 
-// ParseHeader reads from r until \r\n\r\n and unmarshals the header.
+// ParseHeader reads from r until \r\n\r\n and unmarshals the header. A header
+// that cannot be used is reported as a *ProtocolError.
 func ParseHeader(r io.Reader) (*Message, error) {
 	if r == nil {
 		return nil, errors.New("reader nil")
@@ -46,7 +62,10 @@ func ParseHeader(r io.Reader) (*Message, error) {
 	jsonPart := header[:len(header)-4]
 	var msg Message
 	if err := json.Unmarshal(jsonPart, &msg); err != nil {
-		return nil, err
+		return nil, &ProtocolError{Header: jsonPart, Err: err}
+	}
+	if msg.ContentLength < 0 {
+		return nil, &ProtocolError{Header: jsonPart, Err: errors.New("negative content length")}
 	}
 	return &msg, nil
 }
@@ -2,6 +2,8 @@ package message
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"testing"
 )
 
@@ -30,3 +32,34 @@ func TestParseHeaderPeerDisconnect(t *testing.T) {
 		t.Fatalf("expected error")
 	}
 }
+
+func TestParseHeaderProtocolError(t *testing.T) {
+	for _, in := range []string{"not json\r\n\r\n", "{\"len\":-1}\r\n\r\n"} {
+		_, err := ParseHeader(bytes.NewReader([]byte(in)))
+		var perr *ProtocolError
+		if !errors.As(err, &perr) || string(perr.Header)+"\r\n\r\n" != in {
+			t.Errorf("%q: expected protocol error, got %v", in, err)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	data := []byte("123456789")
+	if got := Checksum(data); got != "e3069283" {
+		t.Fatalf("unexpected CRC32C %s", got)
+	}
+	msg := &Message{Checksum: Checksum(data)}
+	if !VerifyChecksum(msg, data) || VerifyChecksum(msg, []byte("12345678X")) {
+		t.Fatalf("VerifyChecksum gave wrong result")
+	}
+
+	mismatches := 0
+	r := VerifyReader(bytes.NewReader([]byte("12345678X")), msg.Checksum, func() { mismatches++ })
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrChecksumMismatch) || mismatches != 1 {
+		t.Fatalf("expected mismatch, got %v (%d)", err, mismatches)
+	}
+	r = VerifyReader(bytes.NewReader(data), msg.Checksum, nil)
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("verified read failed: %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package message
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrDropped is returned by an interceptor to discard a message. Inbound
+// messages are dropped silently; outbound sends return the error to the
+// caller.
+var ErrDropped = errors.New("message dropped")
+
+// Payload carries the body of a message through an interceptor chain. Byte
+// messages populate Data while stream messages populate Stream and Length,
+// which is -1 for chunked streams of unknown length.
+// Interceptors may replace Data or wrap Stream but should not change the
+// kind of payload.
+type Payload struct {
+	Data   []byte
+	Stream io.Reader
+	Length int64
+
+	// Relayed marks a stream forwarded from another peer as it arrives. Its
+	// checksum is the sender's and is passed through rather than computed
+	// again, so the stream is never buffered. Interceptors that wrap Stream
+	// keep the mark.
+	Relayed bool
+}
+
+// IsStream reports whether the payload is backed by a reader.
+func (p *Payload) IsStream() bool { return p.Stream != nil }
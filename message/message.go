@@ -31,5 +31,6 @@ type Message struct {
 	TimestampUtc     time.Time      `json:"ts"`
 	ExpirationUtc    *time.Time     `json:"exp,omitempty"`
 	ConversationGUID string         `json:"convguid"`
+	Checksum         string         `json:"crc32c,omitempty"`
 	SenderGUID       string         `json:"-"`
 }
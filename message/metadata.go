@@ -0,0 +1,69 @@
+package message
+
+// Metadata keys reserved by this library. Applications should avoid using
+// them for their own data.
+const (
+	// MetadataError carries a human-readable reason on StatusFailure replies.
+	MetadataError = "error"
+
+	// MetadataContentType names the encoding of the payload, such as
+	// "application/json".
+	MetadataContentType = "content-type"
+
+	// MetadataPubSub holds the publish/subscribe action of a control message.
+	MetadataPubSub = "pubsub"
+
+	// MetadataTopic holds the topic or subscription pattern of a
+	// publish/subscribe message.
+	MetadataTopic = "topic"
+
+	// MetadataDestination names the client a message should be relayed to
+	// by the server.
+	MetadataDestination = "dest"
+
+	// MetadataSender is stamped by the server on relayed messages with the
+	// id of the originating client. Values supplied by clients are
+	// overwritten.
+	MetadataSender = "sender"
+
+	// MetadataRPCMethod names the method invoked by an RPC request, in the
+	// form "Service.Method".
+	MetadataRPCMethod = "rpc.method"
+
+	// MetadataRPCError marks an RPC response whose payload is a structured
+	// error rather than a reply.
+	MetadataRPCError = "rpc.error"
+
+	// MetadataStreamID ties together the frames of a chunked stream.
+	MetadataStreamID = "stream.id"
+
+	// MetadataStreamSeq numbers the frames of a chunked stream from zero.
+	MetadataStreamSeq = "stream.seq"
+
+	// MetadataStreamEnd marks the final frame of a chunked stream.
+	MetadataStreamEnd = "stream.end"
+
+	// MetadataStreamLength carries the total length of a chunked stream
+	// when it is known in advance.
+	MetadataStreamLength = "stream.len"
+
+	// MetadataStreamCancel aborts a chunked stream and carries the reason.
+	MetadataStreamCancel = "stream.cancel"
+
+	// MetadataFileAction holds the step of a file transfer: "offer",
+	// "data" or "commit".
+	MetadataFileAction = "file.action"
+
+	// MetadataFileName holds the base name of a transferred file.
+	MetadataFileName = "file.name"
+
+	// MetadataFileSize holds the total size of a transferred file in bytes.
+	MetadataFileSize = "file.size"
+
+	// MetadataFileHash holds the hex-encoded SHA-256 of a transferred file.
+	MetadataFileHash = "file.sha256"
+
+	// MetadataFileOffset holds the position of a data block, or the number
+	// of bytes the receiver has acknowledged.
+	MetadataFileOffset = "file.offset"
+)
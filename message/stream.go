@@ -0,0 +1,7 @@
+package message
+
+import "errors"
+
+// ErrStreamCanceled is returned by readers of chunked streams that were
+// canceled by either peer.
+var ErrStreamCanceled = errors.New("stream canceled")
@@ -0,0 +1,79 @@
+// Package pubsub defines the topic syntax and control actions used by the
+// publish/subscribe support in the client and server packages.
+//
+// Topics are dot-separated tokens such as "orders.eu.created". Subscription
+// patterns may use "*" to match exactly one token and ">" as the final token
+// to match one or more remaining tokens.
+package pubsub
+
+import (
+	"errors"
+	"strings"
+)
+
+// Actions carried in message metadata under message.MetadataPubSub.
+const (
+	ActionSubscribe   = "subscribe"
+	ActionUnsubscribe = "unsubscribe"
+	ActionPublish     = "publish"
+	ActionMessage     = "message"
+)
+
+var (
+	// ErrInvalidTopic is returned for empty topics, empty tokens or topics
+	// that contain wildcards.
+	ErrInvalidTopic = errors.New("pubsub: invalid topic")
+
+	// ErrInvalidPattern is returned for malformed subscription patterns.
+	ErrInvalidPattern = errors.New("pubsub: invalid pattern")
+)
+
+// ValidateTopic checks that topic can be published to.
+func ValidateTopic(topic string) error {
+	if topic == "" {
+		return ErrInvalidTopic
+	}
+	for _, tok := range strings.Split(topic, ".") {
+		if tok == "" || strings.ContainsAny(tok, "*>") {
+			return ErrInvalidTopic
+		}
+	}
+	return nil
+}
+
+// ValidatePattern checks that pattern can be subscribed to.
+func ValidatePattern(pattern string) error {
+	if pattern == "" {
+		return ErrInvalidPattern
+	}
+	toks := strings.Split(pattern, ".")
+	for i, tok := range toks {
+		switch {
+		case tok == "":
+			return ErrInvalidPattern
+		case tok == ">" && i != len(toks)-1:
+			return ErrInvalidPattern
+		case tok != "*" && tok != ">" && strings.ContainsAny(tok, "*>"):
+			return ErrInvalidPattern
+		}
+	}
+	return nil
+}
+
+// Match reports whether topic matches the subscription pattern.
+func Match(pattern, topic string) bool {
+	pt := strings.Split(pattern, ".")
+	tt := strings.Split(topic, ".")
+	for i, p := range pt {
+		if p == ">" {
+			return len(tt) > i
+		}
+		if i >= len(tt) {
+			return false
+		}
+		if p != "*" && p != tt[i] {
+			return false
+		}
+	}
+	return len(pt) == len(tt)
+}
@@ -0,0 +1,41 @@
+package pubsub
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"a.b.c", "a.b.c", true},
+		{"a.b.c", "a.b", false},
+		{"a.*.c", "a.x.c", true},
+		{"a.*", "a.x.y", false},
+		{"a.>", "a.x.y", true},
+		{"a.>", "a", false},
+		{">", "a.b", true},
+		{"*.b.>", "x.b.c", true},
+		{"*.b.>", "x.c.c", false},
+	}
+	for _, c := range cases {
+		if got := Match(c.pattern, c.topic); got != c.want {
+			t.Fatalf("Match(%q, %q) = %v", c.pattern, c.topic, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, p := range []string{"a", "a.*", "a.>", "*.b.>"} {
+		if err := ValidatePattern(p); err != nil {
+			t.Fatalf("pattern %q: %v", p, err)
+		}
+	}
+	for _, p := range []string{"", "a..b", "a.>.b", "a*"} {
+		if ValidatePattern(p) == nil {
+			t.Fatalf("pattern %q should be invalid", p)
+		}
+	}
+	if ValidateTopic("a.b") != nil || ValidateTopic("a.*") == nil || ValidateTopic("") == nil {
+		t.Fatalf("unexpected topic validation")
+	}
+}
@@ -0,0 +1,277 @@
+// Package router dispatches WatsonTcp messages to handlers based on the value
+// of a metadata key. A Router can be installed as the OnMessage callback of
+// either a client or a server.
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// Request describes a message being routed.
+type Request struct {
+	// ClientID identifies the sending client when routing on a server. It
+	// is empty when routing on a client.
+	ClientID string
+
+	// Route is the metadata value the message was routed on.
+	Route string
+
+	Message *message.Message
+	Data    []byte
+}
+
+// Response is returned by a Handler. For sync requests it is sent back to
+// the caller as the sync response; for other messages it is discarded.
+type Response struct {
+	// Status defaults to message.StatusNormal when empty.
+	Status   message.MessageStatus
+	Metadata map[string]any
+	Data     []byte
+}
+
+// Handler processes a routed message. If the message is a sync request and
+// the handler returns an error, the caller receives a StatusFailure response
+// carrying the error text under message.MetadataError.
+type Handler func(req *Request) (*Response, error)
+
+// Middleware wraps a Handler to add behavior before or after it runs.
+type Middleware func(next Handler) Handler
+
+// SendFunc writes a reply to the peer a request came from. id is the
+// Request.ClientID and is empty on the client side.
+type SendFunc func(id string, msg *message.Message, data []byte) error
+
+type route struct {
+	pattern string
+	handler Handler
+}
+
+// Router matches the value of a metadata key against registered patterns.
+//
+// A pattern without '*' matches exactly. A pattern whose only '*' is the last
+// character matches any value with the preceding prefix. Any other pattern
+// containing '*' is a wildcard in which each '*' matches any run of
+// characters. Exact routes take precedence over prefixes, longer prefixes
+// over shorter ones, and prefixes over wildcards, which are tried in
+// registration order.
+type Router struct {
+	// ReplyNotFound answers unmatched messages with StatusFailure when no
+	// NotFound handler is registered.
+	ReplyNotFound bool
+
+	// ErrorHandler, if set, receives errors returned by handlers for
+	// messages that are not sync requests, as well as failures to send
+	// replies.
+	ErrorHandler func(req *Request, err error)
+
+	key string
+
+	mu         sync.RWMutex
+	middleware []Middleware
+	exact      map[string]Handler
+	prefixes   []route
+	wildcards  []route
+	notFound   Handler
+}
+
+// New creates a Router that dispatches on the metadata value stored under
+// key.
+func New(key string) *Router {
+	return &Router{key: key, exact: make(map[string]Handler)}
+}
+
+// Key returns the metadata key the router dispatches on.
+func (r *Router) Key() string { return r.key }
+
+// Use appends middleware applied to every route, including the NotFound
+// handler. It only affects routes registered afterwards.
+func (r *Router) Use(mw ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Handle registers h for pattern. mw is applied to this route only, inside
+// any middleware added with Use. Registering the same pattern twice
+// replaces the previous handler.
+func (r *Router) Handle(pattern string, h Handler, mw ...Middleware) {
+	if h == nil {
+		panic("router: nil handler")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h = r.wrap(h, mw)
+	star := strings.IndexByte(pattern, '*')
+	switch {
+	case star < 0:
+		r.exact[pattern] = h
+	case star == len(pattern)-1:
+		r.prefixes = replaceRoute(r.prefixes, route{pattern: pattern[:star], handler: h})
+		sort.SliceStable(r.prefixes, func(i, j int) bool {
+			return len(r.prefixes[i].pattern) > len(r.prefixes[j].pattern)
+		})
+	default:
+		r.wildcards = replaceRoute(r.wildcards, route{pattern: pattern, handler: h})
+	}
+}
+
+// HandleFunc registers fn for pattern. It is a convenience for handlers
+// that never produce a response.
+func (r *Router) HandleFunc(pattern string, fn func(req *Request), mw ...Middleware) {
+	r.Handle(pattern, func(req *Request) (*Response, error) {
+		fn(req)
+		return nil, nil
+	}, mw...)
+}
+
+// NotFound registers the handler used when no route matches, including
+// messages that lack the routing key.
+func (r *Router) NotFound(h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h == nil {
+		r.notFound = nil
+		return
+	}
+	r.notFound = r.wrap(h, nil)
+}
+
+// Match returns the handler registered for value, or nil.
+func (r *Router) Match(value string) Handler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if h, ok := r.exact[value]; ok {
+		return h
+	}
+	for _, rt := range r.prefixes {
+		if strings.HasPrefix(value, rt.pattern) {
+			return rt.handler
+		}
+	}
+	for _, rt := range r.wildcards {
+		if wildcardMatch(rt.pattern, value) {
+			return rt.handler
+		}
+	}
+	return nil
+}
+
+// Serve routes a single message and sends any reply through send.
+func (r *Router) Serve(id string, msg *message.Message, data []byte, send SendFunc) {
+	value, _ := msg.Metadata[r.key].(string)
+	req := &Request{ClientID: id, Route: value, Message: msg, Data: data}
+
+	h := r.Match(value)
+	if h == nil {
+		r.mu.RLock()
+		h = r.notFound
+		r.mu.RUnlock()
+	}
+	if h == nil {
+		if r.ReplyNotFound {
+			r.reply(req, nil, fmt.Errorf("no route for %q", value), send, true)
+		}
+		return
+	}
+	resp, err := h(req)
+	r.reply(req, resp, err, send, false)
+}
+
+// OnServerMessage adapts the router to server.Callbacks.OnMessage. Because
+// the server does not exist yet when callbacks are built, send is usually a
+// closure over a variable assigned after server.New.
+func (r *Router) OnServerMessage(send SendFunc) func(id string, msg *message.Message, data []byte) {
+	return func(id string, msg *message.Message, data []byte) {
+		r.Serve(id, msg, data, send)
+	}
+}
+
+// OnClientMessage adapts the router to client.Callbacks.OnMessage.
+func (r *Router) OnClientMessage(send func(msg *message.Message, data []byte) error) func(msg *message.Message, data []byte) {
+	return func(msg *message.Message, data []byte) {
+		r.Serve("", msg, data, func(_ string, m *message.Message, d []byte) error {
+			return send(m, d)
+		})
+	}
+}
+
+// reply sends the outcome of a handler back to the peer. Sync requests
+// always get a response. Other messages are only answered when force is set,
+// which is used for not-found failures; failures and sync responses are
+// never answered so peers cannot bounce errors back and forth.
+func (r *Router) reply(req *Request, resp *Response, err error, send SendFunc, force bool) {
+	msg := req.Message
+	if !msg.SyncRequest {
+		if err != nil && !force && r.ErrorHandler != nil {
+			r.ErrorHandler(req, err)
+		}
+		if !force || msg.SyncResponse || msg.Status == message.StatusFailure {
+			return
+		}
+	}
+	out := &message.Message{
+		Status:           message.StatusNormal,
+		SyncResponse:     msg.SyncRequest,
+		ConversationGUID: msg.ConversationGUID,
+	}
+	var data []byte
+	if err != nil {
+		out.Status = message.StatusFailure
+		out.Metadata = map[string]any{message.MetadataError: err.Error()}
+	} else if resp != nil {
+		if resp.Status != "" {
+			out.Status = resp.Status
+		}
+		out.Metadata = resp.Metadata
+		data = resp.Data
+	}
+	if serr := send(req.ClientID, out, data); serr != nil && r.ErrorHandler != nil {
+		r.ErrorHandler(req, serr)
+	}
+}
+
+// wrap applies route middleware and then the router-wide middleware so that
+// Use middleware runs first.
+func (r *Router) wrap(h Handler, mw []Middleware) Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](h)
+	}
+	return h
+}
+
+func replaceRoute(routes []route, rt route) []route {
+	for i := range routes {
+		if routes[i].pattern == rt.pattern {
+			routes[i] = rt
+			return routes
+		}
+	}
+	return append(routes, rt)
+}
+
+// wildcardMatch reports whether value matches pattern, where each '*' in
+// pattern matches any run of characters.
+func wildcardMatch(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+	return strings.HasSuffix(value, last)
+}
@@ -0,0 +1,122 @@
+package router
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+type sent struct {
+	id   string
+	msg  *message.Message
+	data []byte
+}
+
+func recorder(out *[]sent) SendFunc {
+	return func(id string, msg *message.Message, data []byte) error {
+		*out = append(*out, sent{id: id, msg: msg, data: data})
+		return nil
+	}
+}
+
+func newMsg(route string, sync bool) *message.Message {
+	return &message.Message{
+		Metadata:         map[string]any{"type": route},
+		SyncRequest:      sync,
+		ConversationGUID: "guid",
+	}
+}
+
+func TestMatchPrecedence(t *testing.T) {
+	r := New("type")
+	var hit string
+	mark := func(name string) func(*Request) {
+		return func(*Request) { hit = name }
+	}
+	r.HandleFunc("user.create", mark("exact"))
+	r.HandleFunc("user.*", mark("prefix"))
+	r.HandleFunc("user.admin.*", mark("longer"))
+	r.HandleFunc("*.delete", mark("wildcard"))
+
+	cases := map[string]string{
+		"user.create":       "exact",
+		"user.update":       "prefix",
+		"user.admin.create": "longer",
+		"group.delete":      "wildcard",
+	}
+	for value, want := range cases {
+		hit = ""
+		r.Serve("", newMsg(value, false), nil, recorder(new([]sent)))
+		if hit != want {
+			t.Fatalf("%s: expected %s got %q", value, want, hit)
+		}
+	}
+	if r.Match("other") != nil {
+		t.Fatalf("expected no match")
+	}
+}
+
+func TestSyncReply(t *testing.T) {
+	r := New("type")
+	r.Handle("echo", func(req *Request) (*Response, error) {
+		return &Response{Data: req.Data, Metadata: map[string]any{"ok": true}}, nil
+	})
+	r.Handle("fail", func(req *Request) (*Response, error) {
+		return nil, errors.New("boom")
+	})
+
+	var out []sent
+	r.Serve("c1", newMsg("echo", true), []byte("hi"), recorder(&out))
+	r.Serve("c1", newMsg("fail", true), nil, recorder(&out))
+	r.Serve("c1", newMsg("echo", false), []byte("ignored"), recorder(&out))
+	if len(out) != 2 {
+		t.Fatalf("expected 2 replies got %d", len(out))
+	}
+	if out[0].id != "c1" || string(out[0].data) != "hi" || !out[0].msg.SyncResponse || out[0].msg.ConversationGUID != "guid" {
+		t.Fatalf("bad echo reply: %+v", out[0])
+	}
+	if out[1].msg.Status != message.StatusFailure || out[1].msg.Metadata[message.MetadataError] != "boom" {
+		t.Fatalf("bad failure reply: %+v", out[1].msg)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	r := New("type")
+	r.ReplyNotFound = true
+	var out []sent
+	r.Serve("", newMsg("missing", false), nil, recorder(&out))
+	if len(out) != 1 || out[0].msg.Status != message.StatusFailure || out[0].msg.SyncResponse {
+		t.Fatalf("expected failure reply, got %+v", out)
+	}
+
+	called := false
+	r.NotFound(func(req *Request) (*Response, error) {
+		called = true
+		return nil, nil
+	})
+	out = nil
+	r.Serve("", &message.Message{}, nil, recorder(&out))
+	if !called || len(out) != 0 {
+		t.Fatalf("expected fallback handler without reply")
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	r := New("type")
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(req *Request) (*Response, error) {
+				order = append(order, name)
+				return next(req)
+			}
+		}
+	}
+	r.Use(mw("global"))
+	r.HandleFunc("a", func(*Request) { order = append(order, "handler") }, mw("route"))
+	r.Serve("", newMsg("a", false), nil, recorder(new([]sent)))
+	if len(order) != 3 || order[0] != "global" || order[1] != "route" || order[2] != "handler" {
+		t.Fatalf("unexpected order %v", order)
+	}
+}
@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/WasimAhmad/watsontcp-go/client"
+	"github.com/WasimAhmad/watsontcp-go/codec"
+	"github.com/WasimAhmad/watsontcp-go/message"
+	"github.com/WasimAhmad/watsontcp-go/server"
+)
+
+// Client invokes methods registered on the remote side of a connection.
+type Client struct {
+	// Codec encodes arguments. Nil means codec.JSON.
+	Codec codec.Codec
+
+	sendSync func(ctx context.Context, msg *message.Message, data []byte) (*message.Message, []byte, error)
+}
+
+// NewClient returns a Client that calls methods on the server c is
+// connected to.
+func NewClient(c *client.Client) *Client {
+	return &Client{sendSync: c.SendSync}
+}
+
+// NewClientFor returns a Client that calls methods on the client identified
+// by id, allowing a server to call into its clients.
+func NewClientFor(s *server.Server, id string) *Client {
+	return &Client{sendSync: func(ctx context.Context, msg *message.Message, data []byte) (*message.Message, []byte, error) {
+		return s.SendSync(ctx, id, msg, data)
+	}}
+}
+
+// Invoke calls method with args and decodes the result into reply, which
+// must be a pointer or nil. Errors returned by the remote method are
+// reported as *Error.
+func (c *Client) Invoke(ctx context.Context, method string, args any, reply any) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cdc := c.Codec
+	if cdc == nil {
+		cdc = codec.JSON
+	}
+	msg := &message.Message{
+		Status:        message.StatusNormal,
+		Metadata:      map[string]any{message.MetadataRPCMethod: method},
+		ExpirationUtc: deadline(ctx),
+	}
+	data, err := codec.EncodeWith(cdc, msg, args)
+	if err != nil {
+		return err
+	}
+	resp, respData, err := c.sendSync(ctx, msg, data)
+	if err != nil {
+		return err
+	}
+	if resp.Status == message.StatusFailure {
+		if flag, _ := resp.Metadata[message.MetadataRPCError].(bool); flag {
+			var rerr Error
+			if err := json.Unmarshal(respData, &rerr); err == nil {
+				return &rerr
+			}
+		}
+		reason, _ := resp.Metadata[message.MetadataError].(string)
+		return &Error{Code: CodeRemote, Message: reason}
+	}
+	if reply == nil {
+		return nil
+	}
+	rc, err := codec.ForMessage(resp)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(rc, respData, reply)
+}
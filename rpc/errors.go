@@ -0,0 +1,42 @@
+package rpc
+
+import "fmt"
+
+// Error codes carried by Error.
+const (
+	// CodeApplication is used for errors returned by a method that are not
+	// themselves an *Error.
+	CodeApplication = 1
+
+	// CodeMethodNotFound reports that no method is registered under the
+	// requested name.
+	CodeMethodNotFound = 2
+
+	// CodeInvalidArgs reports that the arguments could not be decoded.
+	CodeInvalidArgs = 3
+
+	// CodeInternal reports a failure inside the RPC layer, such as a panic
+	// in a method or a reply that could not be encoded.
+	CodeInternal = 4
+
+	// CodeRemote reports a failure reply that did not come from an RPC
+	// server, for example a rejection by an interceptor.
+	CodeRemote = 5
+)
+
+// Error is a structured error returned to RPC callers. Methods may return
+// an *Error to control the code and data seen by the caller.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// Errorf returns an *Error with the given code and formatted message.
+func Errorf(code int, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
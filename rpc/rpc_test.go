@@ -0,0 +1,161 @@
+package rpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/client"
+	"github.com/WasimAhmad/watsontcp-go/codec"
+	"github.com/WasimAhmad/watsontcp-go/message"
+	"github.com/WasimAhmad/watsontcp-go/rpc"
+	"github.com/WasimAhmad/watsontcp-go/server"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith struct{}
+
+func (Arith) Multiply(args Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func (Arith) Divide(ctx context.Context, args *Args, reply *int) error {
+	if args.B == 0 {
+		return rpc.Errorf(42, "divide by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+func (Arith) Fail(args Args, reply *int) error {
+	return errors.New("failed")
+}
+
+// panicCodec is JSON, except that decoding into a *panicReply panics.
+type panicCodec struct{}
+
+type panicReply struct{}
+
+func (panicCodec) ContentType() string           { return "application/x-test-panic" }
+func (panicCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+func (panicCodec) Unmarshal(data []byte, v any) error {
+	if _, ok := v.(*panicReply); ok {
+		panic("bad reply")
+	}
+	return json.Unmarshal(data, v)
+}
+
+func TestRegisterNil(t *testing.T) {
+	rs := rpc.NewServer()
+	if err := rs.Register(nil); err == nil {
+		t.Fatal("expected an error registering nil")
+	}
+	if err := rs.RegisterName("Nil", nil); err == nil {
+		t.Fatal("expected an error registering nil by name")
+	}
+}
+
+func TestInvoke(t *testing.T) {
+	rs := rpc.NewServer()
+	if err := rs.Register(Arith{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	callers := make(chan string, 1)
+	rs.RegisterFunc("Info.Caller", func(ctx context.Context, _ struct{}, reply *string) error {
+		*reply = rpc.CallerID(ctx)
+		callers <- *reply
+		return nil
+	})
+
+	var srv *server.Server
+	// calls back into the caller over the same connection
+	rs.RegisterFunc("Info.Nested", func(ctx context.Context, args string, reply *string) error {
+		return rpc.NewClientFor(srv, rpc.CallerID(ctx)).Invoke(ctx, "Client.Echo", args, reply)
+	})
+	ids := make(chan string, 1)
+	srv = server.New("127.0.0.1:30170", nil, server.Callbacks{
+		OnConnect: func(id string, conn net.Conn) { ids <- id },
+		OnMessage: rs.OnServerMessage(func(id string, msg *message.Message, data []byte) error {
+			return srv.Send(id, msg, data)
+		}),
+	}, nil)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	defer srv.Stop()
+
+	cs := rpc.NewServer()
+	cs.RegisterFunc("Client.Echo", func(args string, reply *string) error {
+		*reply = "echo " + args
+		return nil
+	})
+	var cli *client.Client
+	cli = client.New("127.0.0.1:30170", nil, client.Callbacks{
+		OnMessage: cs.OnClientMessage(func(msg *message.Message, data []byte) error {
+			return cli.Send(msg, data)
+		}),
+	}, nil)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer cli.Disconnect()
+	id := <-ids
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	rc := rpc.NewClient(cli)
+
+	var product int
+	if err := rc.Invoke(ctx, "Arith.Multiply", Args{6, 7}, &product); err != nil || product != 42 {
+		t.Fatalf("multiply: %v %d", err, product)
+	}
+
+	rc.Codec = codec.Gob
+	var quotient int
+	if err := rc.Invoke(ctx, "Arith.Divide", Args{9, 3}, &quotient); err != nil || quotient != 3 {
+		t.Fatalf("divide: %v %d", err, quotient)
+	}
+
+	var rerr *rpc.Error
+	err := rc.Invoke(ctx, "Arith.Divide", Args{1, 0}, &quotient)
+	if !errors.As(err, &rerr) || rerr.Code != 42 {
+		t.Fatalf("expected code 42, got %v", err)
+	}
+	err = rc.Invoke(ctx, "Arith.Fail", Args{}, nil)
+	if !errors.As(err, &rerr) || rerr.Code != rpc.CodeApplication || rerr.Message != "failed" {
+		t.Fatalf("expected application error, got %v", err)
+	}
+	err = rc.Invoke(ctx, "Arith.Missing", Args{}, nil)
+	if !errors.As(err, &rerr) || rerr.Code != rpc.CodeMethodNotFound {
+		t.Fatalf("expected method not found, got %v", err)
+	}
+
+	var caller string
+	if err := rpc.NewClient(cli).Invoke(ctx, "Info.Caller", struct{}{}, &caller); err != nil || caller != id {
+		t.Fatalf("caller: %v %q", err, caller)
+	}
+	<-callers
+
+	var echo string
+	if err := rpc.NewClientFor(srv, id).Invoke(ctx, "Client.Echo", "hi", &echo); err != nil || echo != "echo hi" {
+		t.Fatalf("server to client: %v %q", err, echo)
+	}
+	echo = ""
+	if err := rc.Invoke(ctx, "Info.Nested", "again", &echo); err != nil || echo != "echo again" {
+		t.Fatalf("nested call: %v %q", err, echo)
+	}
+
+	codec.Register(panicCodec{})
+	rc.Codec = panicCodec{}
+	var derr *codec.DecodeError
+	if err := rc.Invoke(ctx, "Arith.Multiply", Args{2, 3}, &panicReply{}); !errors.As(err, &derr) {
+		t.Fatalf("expected a decode error from a panicking codec, got %v", err)
+	}
+}
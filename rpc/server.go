@@ -0,0 +1,254 @@
+// Package rpc implements method-based remote procedure calls on top of
+// SendSync. Either side of a connection can register methods and either side
+// can invoke them, so a server may call into its clients as well.
+//
+// A request is a sync message whose metadata names the method under
+// message.MetadataRPCMethod and whose payload holds the codec-encoded
+// arguments. Successful responses carry the encoded reply; failures carry a
+// JSON-encoded *Error and are flagged with message.MetadataRPCError.
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/codec"
+	"github.com/WasimAhmad/watsontcp-go/message"
+	"github.com/WasimAhmad/watsontcp-go/router"
+)
+
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+type callerKey struct{}
+
+// CallerID returns the id of the client that issued the call handled with
+// ctx. It is empty for calls served by a client.
+func CallerID(ctx context.Context) string {
+	id, _ := ctx.Value(callerKey{}).(string)
+	return id
+}
+
+// Server dispatches RPC requests to registered methods.
+type Server struct {
+	router *router.Router
+}
+
+// NewServer creates an empty Server.
+func NewServer() *Server {
+	s := &Server{router: router.New(message.MetadataRPCMethod)}
+	s.router.NotFound(func(req *router.Request) (*router.Response, error) {
+		return errorResponse(Errorf(CodeMethodNotFound, "method %q not found", req.Route)), nil
+	})
+	return s
+}
+
+// Use adds router middleware run before every method registered afterwards.
+func (s *Server) Use(mw ...router.Middleware) { s.router.Use(mw...) }
+
+// Register publishes the suitable exported methods of rcvr under the name
+// of its concrete type, as "Type.Method".
+func (s *Server) Register(rcvr any) error {
+	t := reflect.TypeOf(rcvr)
+	if t == nil {
+		return errors.New("rpc: cannot register nil")
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	name := t.Name()
+	if name == "" {
+		return errors.New("rpc: cannot register unnamed type")
+	}
+	return s.RegisterName(name, rcvr)
+}
+
+// RegisterName is like Register but uses name for the service.
+//
+// Suitable methods have one of the forms
+//
+//	func (t *T) Method(args A, reply *R) error
+//	func (t *T) Method(ctx context.Context, args A, reply *R) error
+func (s *Server) RegisterName(name string, rcvr any) error {
+	if rcvr == nil {
+		return errors.New("rpc: cannot register nil")
+	}
+	v := reflect.ValueOf(rcvr)
+	t := v.Type()
+	n := 0
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		if !m.IsExported() {
+			continue
+		}
+		if h, err := newMethod(v.Method(i)); err == nil {
+			s.router.Handle(name+"."+m.Name, h)
+			n++
+		}
+	}
+	if n == 0 {
+		return fmt.Errorf("rpc: type %s has no suitable methods", t)
+	}
+	return nil
+}
+
+// RegisterFunc publishes fn under method, which is usually of the form
+// "Service.Method". fn must have one of the signatures accepted by
+// RegisterName, without the receiver.
+func (s *Server) RegisterFunc(method string, fn any) error {
+	h, err := newMethod(reflect.ValueOf(fn))
+	if err != nil {
+		return fmt.Errorf("rpc: %s: %w", method, err)
+	}
+	s.router.Handle(method, h)
+	return nil
+}
+
+// Serve handles msg if it is an RPC request and reports whether it did.
+// It allows an RPC server to share an OnMessage callback with other
+// handlers. The method runs on its own goroutine, so it may itself make
+// calls over the connection the request arrived on without blocking the
+// read loop that delivers their responses.
+func (s *Server) Serve(id string, msg *message.Message, data []byte, send router.SendFunc) bool {
+	if _, ok := msg.Metadata[message.MetadataRPCMethod]; !ok || !msg.SyncRequest {
+		return false
+	}
+	go s.router.Serve(id, msg, data, send)
+	return true
+}
+
+// OnServerMessage adapts the RPC server to server.Callbacks.OnMessage.
+// Messages that are not RPC requests are ignored.
+func (s *Server) OnServerMessage(send router.SendFunc) func(id string, msg *message.Message, data []byte) {
+	return func(id string, msg *message.Message, data []byte) {
+		s.Serve(id, msg, data, send)
+	}
+}
+
+// OnClientMessage adapts the RPC server to client.Callbacks.OnMessage so
+// that the server side of a connection can call into the client.
+func (s *Server) OnClientMessage(send func(msg *message.Message, data []byte) error) func(msg *message.Message, data []byte) {
+	return func(msg *message.Message, data []byte) {
+		s.Serve("", msg, data, func(_ string, m *message.Message, d []byte) error {
+			return send(m, d)
+		})
+	}
+}
+
+// newMethod validates fn and wraps it in a router handler.
+func newMethod(fn reflect.Value) (router.Handler, error) {
+	ft := fn.Type()
+	if ft.Kind() != reflect.Func {
+		return nil, errors.New("not a function")
+	}
+	withCtx := ft.NumIn() == 3 && ft.In(0) == typeOfContext
+	if ft.NumIn() != 2 && !withCtx {
+		return nil, errors.New("wrong number of arguments")
+	}
+	argIdx := 0
+	if withCtx {
+		argIdx = 1
+	}
+	argType := ft.In(argIdx)
+	replyType := ft.In(argIdx + 1)
+	if replyType.Kind() != reflect.Pointer {
+		return nil, errors.New("reply must be a pointer")
+	}
+	if ft.NumOut() != 1 || ft.Out(0) != typeOfError {
+		return nil, errors.New("must return exactly one error")
+	}
+
+	return func(req *router.Request) (resp *router.Response, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				resp, err = errorResponse(Errorf(CodeInternal, "panic: %v", r)), nil
+			}
+		}()
+		c, err := codec.ForMessage(req.Message)
+		if err != nil {
+			return errorResponse(Errorf(CodeInvalidArgs, "%v", err)), nil
+		}
+
+		var argv reflect.Value
+		if argType.Kind() == reflect.Pointer {
+			argv = reflect.New(argType.Elem())
+		} else {
+			argv = reflect.New(argType)
+		}
+		if len(req.Data) > 0 {
+			if err := c.Unmarshal(req.Data, argv.Interface()); err != nil {
+				return errorResponse(Errorf(CodeInvalidArgs, "%v", err)), nil
+			}
+		}
+		if argType.Kind() != reflect.Pointer {
+			argv = argv.Elem()
+		}
+		replyv := reflect.New(replyType.Elem())
+
+		in := []reflect.Value{argv, replyv}
+		if withCtx {
+			ctx, cancel := callContext(req)
+			defer cancel()
+			in = append([]reflect.Value{reflect.ValueOf(ctx)}, in...)
+		}
+		if errv := fn.Call(in)[0]; !errv.IsNil() {
+			callErr := errv.Interface().(error)
+			var rerr *Error
+			if !errors.As(callErr, &rerr) {
+				rerr = &Error{Code: CodeApplication, Message: callErr.Error()}
+			}
+			return errorResponse(rerr), nil
+		}
+
+		data, err := c.Marshal(replyv.Interface())
+		if err != nil {
+			return errorResponse(Errorf(CodeInternal, "encode reply: %v", err)), nil
+		}
+		return &router.Response{
+			Metadata: map[string]any{message.MetadataContentType: c.ContentType()},
+			Data:     data,
+		}, nil
+	}, nil
+}
+
+// callContext carries the caller id and honors the request expiration set
+// from the caller's deadline.
+func callContext(req *router.Request) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(context.Background(), callerKey{}, req.ClientID)
+	if exp := req.Message.ExpirationUtc; exp != nil {
+		return context.WithDeadline(ctx, *exp)
+	}
+	return context.WithCancel(ctx)
+}
+
+func errorResponse(e *Error) *router.Response {
+	data, err := json.Marshal(e)
+	if err != nil {
+		data, _ = json.Marshal(&Error{Code: e.Code, Message: e.Message})
+	}
+	return &router.Response{
+		Status: message.StatusFailure,
+		Metadata: map[string]any{
+			message.MetadataRPCError:    true,
+			message.MetadataError:       e.Message,
+			message.MetadataContentType: codec.JSON.ContentType(),
+		},
+		Data: data,
+	}
+}
+
+// deadline converts a context deadline to the message expiration field.
+func deadline(ctx context.Context) *time.Time {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	d = d.UTC()
+	return &d
+}
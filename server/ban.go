@@ -0,0 +1,256 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/internal/proxyproto"
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// BanPolicy configures temporary bans of source addresses that repeatedly
+// fail authentication or violate the protocol.
+type BanPolicy struct {
+	// MaxFailures is the number of failures within Window that bans an
+	// address. Zero disables automatic bans; Server.Ban still works.
+	MaxFailures int
+
+	// Window is the period over which failures are counted. Zero uses ten
+	// minutes.
+	Window time.Duration
+
+	// Duration is the length of the first ban. Each further ban of the same
+	// address doubles it, up to MaxDuration. Zero uses one minute.
+	Duration time.Duration
+
+	// MaxDuration caps the escalation. An address that goes this long
+	// without being banned starts again from Duration. Zero uses 24 hours.
+	MaxDuration time.Duration
+}
+
+func (p BanPolicy) window() time.Duration {
+	if p.Window <= 0 {
+		return 10 * time.Minute
+	}
+	return p.Window
+}
+
+func (p BanPolicy) maxDuration() time.Duration {
+	if p.MaxDuration <= 0 {
+		return 24 * time.Hour
+	}
+	return p.MaxDuration
+}
+
+// duration returns the length of the nth ban of an address.
+func (p BanPolicy) duration(n int) time.Duration {
+	d := p.Duration
+	if d <= 0 {
+		d = time.Minute
+	}
+	limit := p.maxDuration()
+	for i := 1; i < n && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+// BanEntry describes a banned address.
+type BanEntry struct {
+	IP     string
+	Reason string
+	Since  time.Time
+	Until  time.Time
+
+	// Count is the number of times the address has been banned, including
+	// this one.
+	Count int
+}
+
+type banState struct {
+	failures []time.Time
+	count    int
+	entry    BanEntry
+}
+
+// banTable tracks failures and bans per source address.
+type banTable struct {
+	mu      sync.Mutex
+	entries map[netip.Addr]*banState
+}
+
+func (t *banTable) state(ip netip.Addr) *banState {
+	if t.entries == nil {
+		t.entries = make(map[netip.Addr]*banState)
+	}
+	st := t.entries[ip]
+	if st == nil {
+		st = &banState{}
+		t.entries[ip] = st
+	}
+	return st
+}
+
+func (t *banTable) banned(ip netip.Addr, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.entries[ip]
+	return st != nil && now.Before(st.entry.Until)
+}
+
+// fail records a failure and bans ip once the policy threshold is reached.
+func (t *banTable) fail(ip netip.Addr, now time.Time, policy BanPolicy, reason string) (BanEntry, bool) {
+	if policy.MaxFailures <= 0 {
+		return BanEntry{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.state(ip)
+	cutoff := now.Add(-policy.window())
+	st.failures = slices.DeleteFunc(st.failures, func(f time.Time) bool { return f.Before(cutoff) })
+	st.failures = append(st.failures, now)
+	if len(st.failures) < policy.MaxFailures {
+		return BanEntry{}, false
+	}
+	return st.ban(ip, now, 0, policy, reason), true
+}
+
+// ban bans ip for d, or for the policy's escalating duration when d is zero.
+func (t *banTable) ban(ip netip.Addr, now time.Time, d time.Duration, policy BanPolicy, reason string) BanEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state(ip).ban(ip, now, d, policy, reason)
+}
+
+func (st *banState) ban(ip netip.Addr, now time.Time, d time.Duration, policy BanPolicy, reason string) BanEntry {
+	if !st.entry.Until.IsZero() && now.Sub(st.entry.Until) > policy.maxDuration() {
+		st.count = 0
+	}
+	st.count++
+	if d <= 0 {
+		d = policy.duration(st.count)
+	}
+	st.failures = nil
+	st.entry = BanEntry{IP: ip.String(), Reason: reason, Since: now, Until: now.Add(d), Count: st.count}
+	return st.entry
+}
+
+func (t *banTable) unban(ip netip.Addr) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.entries[ip]
+	delete(t.entries, ip)
+	return ok
+}
+
+func (t *banTable) list(now time.Time) []BanEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []BanEntry
+	for _, st := range t.entries {
+		if now.Before(st.entry.Until) {
+			out = append(out, st.entry)
+		}
+	}
+	slices.SortFunc(out, func(a, b BanEntry) int { return a.Until.Compare(b.Until) })
+	return out
+}
+
+// prune forgets addresses with no active ban, no failures in the window and
+// no ban recent enough to escalate.
+func (t *banTable) prune(now time.Time, policy BanPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cutoff := now.Add(-policy.window())
+	for ip, st := range t.entries {
+		st.failures = slices.DeleteFunc(st.failures, func(f time.Time) bool { return f.Before(cutoff) })
+		if len(st.failures) == 0 && now.Sub(st.entry.Until) > policy.maxDuration() {
+			delete(t.entries, ip)
+		}
+	}
+}
+
+// Ban rejects connections from ip for d and disconnects its current
+// clients. A zero d uses the escalating duration from Options.Ban.
+func (s *Server) Ban(ip string, d time.Duration, reason string) error {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return err
+	}
+	addr = addr.Unmap()
+	s.banned(s.bans.ban(addr, time.Now(), d, s.options.Ban, reason), addr)
+	return nil
+}
+
+// Unban lifts a ban on ip and forgets its failures and earlier bans. It
+// reports whether anything was recorded for the address.
+func (s *Server) Unban(ip string) (bool, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, err
+	}
+	return s.bans.unban(addr.Unmap()), nil
+}
+
+// Bans returns the active bans, soonest to expire first.
+func (s *Server) Bans() []BanEntry {
+	return s.bans.list(time.Now())
+}
+
+// addrBanned reports whether addr is an IP address under an active ban.
+func (s *Server) addrBanned(addr net.Addr) bool {
+	ip, ok := addrIP(addr)
+	return ok && s.bans.banned(ip, time.Now())
+}
+
+// recordFailure counts an authentication failure or protocol violation
+// against the address of conn and bans it once Options.Ban is exceeded.
+func (s *Server) recordFailure(conn net.Conn, reason string) {
+	ip, ok := addrIP(conn.RemoteAddr())
+	if !ok {
+		return
+	}
+	s.logf("%s from %s", reason, ip)
+	if entry, ok := s.bans.fail(ip, time.Now(), s.options.Ban, reason); ok {
+		s.banned(entry, ip)
+	}
+}
+
+// banned reports a new ban and disconnects clients connected from ip.
+func (s *Server) banned(entry BanEntry, ip netip.Addr) {
+	s.logf("banned %s until %s: %s", entry.IP, entry.Until.Format(time.RFC3339), entry.Reason)
+	var drop []string
+	s.mu.Lock()
+	for id, c := range s.conns {
+		if cip, ok := addrIP(c.conn.RemoteAddr()); ok && cip == ip {
+			drop = append(drop, id)
+		}
+	}
+	s.mu.Unlock()
+	s.removeAll(drop)
+	if s.callbacks.OnBan != nil {
+		s.callbacks.OnBan(entry)
+	}
+}
+
+// protocolViolation reports whether err from reading a header means the
+// peer sent something other than WatsonTcp framing.
+func protocolViolation(err error) bool {
+	var perr *message.ProtocolError
+	return errors.As(err, &perr) || errors.Is(err, proxyproto.ErrUntrusted)
+}
+
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	if addr == nil {
+		return netip.Addr{}, false
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ap.Addr().Unmap(), true
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/internal/chunk"
+	"github.com/WasimAhmad/watsontcp-go/internal/filexfer"
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// SendChunked streams r to the client identified by id until EOF without
+// knowing its length in advance. The data is sent as a sequence of frames of
+// up to Options.ChunkSize bytes and the write lock is released between
+// chunks, so other messages can be sent to the client while the stream is in
+// progress. The client receives the stream as a single reader in OnStream,
+// or as one buffered payload in OnMessage.
+//
+// Sending stops and the stream is canceled at the receiver when ctx is done
+// or r returns an error. If the receiver cancels the stream, SendChunked
+// returns an error wrapping message.ErrStreamCanceled. A blocked Read on r
+// is not interrupted.
+func (s *Server) SendChunked(ctx context.Context, id string, msg *message.Message, r io.Reader) error {
+	if r == nil {
+		return errors.New("reader nil")
+	}
+	c := s.client(id)
+	if c == nil {
+		return ErrUnknownClient
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	p := &message.Payload{Stream: r, Length: -1}
+	if err := s.intercept(s.options.SendInterceptors, id, msg, p); err != nil {
+		return err
+	}
+	s.logf("sending chunked stream to %s: %+v", id, msg)
+	return s.sendChunks(ctx, c, id, msg, p.Stream)
+}
+
+// sendChunks writes r to c as a chunked stream. With multiplexing enabled
+// each stream is its own logical channel.
+func (s *Server) sendChunks(ctx context.Context, c *clientConn, id string, msg *message.Message, r io.Reader) error {
+	streamID := newGUID()
+	return chunk.Send(ctx, c.chunks, streamID, s.options.ChunkSize, msg, r, func(m *message.Message, data []byte) error {
+		return s.writeOn(c, id, streamID, m, &message.Payload{Data: data})
+	})
+}
+
+// readChunk reads the payload of a chunked stream frame and hands it to the
+// client's demultiplexer. It returns false if the connection failed.
+func (s *Server) readChunk(c *clientConn, id string, msg *message.Message) bool {
+	payload := make([]byte, msg.ContentLength)
+	if _, err := io.ReadFull(c.conn, payload); err != nil {
+		s.report(id, err)
+		return false
+	}
+	s.stats.IncrementReceivedMessages()
+	s.stats.AddReceivedBytes(int64(len(payload)))
+	s.mu.Lock()
+	c.lastActive = time.Now()
+	s.mu.Unlock()
+
+	f, _ := chunk.Parse(msg, payload)
+	if err := s.verify(id, msg, payload); err != nil {
+		c.chunks.Fail(f, err)
+		return true
+	}
+	rd := c.chunks.Handle(f)
+	if rd == nil {
+		return true
+	}
+	msg.ContentLength = f.Length
+	p := &message.Payload{Stream: rd, Length: f.Length}
+	if err := s.intercept(s.options.ReceiveInterceptors, id, msg, p); err != nil {
+		s.logf("rejected chunked stream from %s: %v", id, err)
+		rd.Close()
+		return true
+	}
+	go s.deliverStream(id, msg, rd, p.Stream)
+	return true
+}
+
+// deliverStream hands a chunked stream to OnStream, or buffers it for
+// OnMessage, and cancels whatever the callback leaves unread.
+func (s *Server) deliverStream(id string, msg *message.Message, rd *chunk.Reader, r io.Reader) {
+	defer rd.Close()
+	controlMsg := s.options.PubSub && msg.Metadata[message.MetadataPubSub] != nil || filexfer.IsTransfer(msg)
+	if s.callbacks.OnStream != nil && s.callbacks.OnMessage == nil && !controlMsg {
+		s.callbacks.OnStream(id, msg, r)
+		return
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		s.logf("chunked stream from %s failed: %v", id, err)
+		return
+	}
+	if controlMsg && s.handlePubSub(id, msg, data) {
+		return
+	}
+	if filexfer.IsTransfer(msg) {
+		s.handleFile(id, msg, data)
+		return
+	}
+	if s.callbacks.OnMessage != nil {
+		s.callbacks.OnMessage(id, msg, data)
+	}
+}
+
+// chunkCanceler returns the function a client's demultiplexer uses to
+// cancel streams it no longer wants.
+func (s *Server) chunkCanceler(id string) func(streamID, reason, dest string) {
+	return func(streamID, reason, dest string) {
+		c := s.client(id)
+		if c == nil {
+			return
+		}
+		if err := s.write(c, id, chunk.CancelMessage(streamID, reason, dest), &message.Payload{}); err != nil {
+			s.logf("cancel stream %s to %s failed: %v", streamID, id, err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// ErrUnknownClient is returned when no connected client has the given id.
+var ErrUnknownClient = errors.New("unknown client")
+
+// ErrNotConnected is returned by SendSync when the client disconnects before
+// it responds.
+var ErrNotConnected = errors.New("client not connected")
+
+// report passes err to Callbacks.OnError unless it only signals that the
+// connection was closed.
+func (s *Server) report(id string, err error) {
+	if s.callbacks.OnError == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return
+	}
+	s.callbacks.OnError(id, err)
+}
+
+// disconnectReason classifies the error that ended a connection.
+func disconnectReason(err error) message.DisconnectReason {
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+		return message.DisconnectNormal
+	case protocolViolation(err):
+		return message.DisconnectProtocolError
+	case errors.Is(err, syscall.ETIMEDOUT):
+		// keepalive probes went unanswered
+		return message.DisconnectHeartbeatLost
+	default:
+		return message.DisconnectIOError
+	}
+}
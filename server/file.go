@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+
+	"github.com/WasimAhmad/watsontcp-go/internal/filexfer"
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// FileHandler is called after a file sent with SendFile has been received
+// from client id, verified and moved to path.
+type FileHandler func(id, name, path string)
+
+// SendFile transfers the file at path to the client identified by id, which
+// must have called ReceiveFile. Data is sent in acknowledged blocks; if the
+// transfer is interrupted, calling SendFile again once the client has
+// reconnected resumes from the last block it acknowledged.
+func (s *Server) SendFile(ctx context.Context, id, path string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return filexfer.Send(ctx, path, func(ctx context.Context, msg *message.Message, data []byte) (*message.Message, []byte, error) {
+		return s.SendSync(ctx, id, msg, data)
+	})
+}
+
+// ReceiveFile accepts files sent by clients with SendFile, storing them in
+// dir and calling handler for each completed file. Partial transfers are kept
+// in dir as hidden ".part" files until they complete.
+func (s *Server) ReceiveFile(dir string, handler FileHandler) {
+	s.filesMu.Lock()
+	s.files = filexfer.NewReceiver(dir)
+	s.fileHandler = handler
+	s.filesMu.Unlock()
+}
+
+// handleFile answers a file transfer step from client id.
+func (s *Server) handleFile(id string, msg *message.Message, data []byte) {
+	s.filesMu.Lock()
+	recv, handler := s.files, s.fileHandler
+	s.filesMu.Unlock()
+	c := s.client(id)
+	if c == nil {
+		return
+	}
+	if recv == nil {
+		s.write(c, id, filexfer.Reject(msg), &message.Payload{})
+		return
+	}
+	reply, path := recv.Handle(msg, data)
+	if err := s.write(c, id, reply, &message.Payload{}); err != nil {
+		s.logf("file transfer reply to %s failed: %v", id, err)
+	}
+	if path != "" && handler != nil {
+		name, _ := msg.Metadata[message.MetadataFileName].(string)
+		handler(id, name, path)
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/internal/proxyproto"
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// handshakeTimeout bounds the TLS handshake performed before OnAccept.
+const handshakeTimeout = 10 * time.Second
+
+// ClientInfo describes a connected client.
+type ClientInfo struct {
+	ID          string
+	RemoteAddr  net.Addr
+	ConnectedAt time.Time
+
+	// Credentials identifies the peer process of a Unix domain socket
+	// connection. It is nil for other transports and on platforms that do
+	// not report peer credentials.
+	Credentials *PeerCredentials
+}
+
+// PeerCredentials holds the process, user and group ids of a local peer.
+type PeerCredentials struct {
+	PID int
+	UID uint32
+	GID uint32
+}
+
+// ClientInfo returns details about the client identified by id.
+func (s *Server) ClientInfo(id string) (ClientInfo, bool) {
+	c := s.client(id)
+	if c == nil {
+		return ClientInfo{}, false
+	}
+	return ClientInfo{
+		ID:          id,
+		RemoteAddr:  c.conn.RemoteAddr(),
+		ConnectedAt: c.connectedAt,
+		Credentials: c.creds,
+	}, true
+}
+
+// admit applies the connection filters and OnAccept to conn and chooses its
+// client id, which is the remote address for IP connections. A non-empty
+// reason means the connection is refused.
+func (s *Server) admit(conn net.Conn) (id string, creds *PeerCredentials, reason string) {
+	if pc, ok := proxyConn(conn); ok {
+		if err := pc.Err(); err != nil {
+			return "", nil, err.Error()
+		}
+	}
+	addr := conn.RemoteAddr()
+	if uc, ok := rawConn(conn).(*net.UnixConn); ok {
+		// unix peers have no usable address; the uid policy replaces the
+		// IP lists
+		id = "unix:" + newGUID()
+		if creds, reason = s.admitUnix(uc); reason != "" {
+			return "", nil, reason
+		}
+	} else {
+		if s.addrBanned(addr) {
+			return "", nil, "banned"
+		}
+		if !s.addrAllowed(addr) {
+			return "", nil, "address not permitted"
+		}
+		switch _, isIP := addrIP(addr); {
+		case isIP:
+			id = addr.String()
+		case addr == nil:
+			id = "conn:" + newGUID()
+		default:
+			id = addr.Network() + ":" + newGUID()
+		}
+	}
+	if reason = s.accept(conn); reason != "" {
+		return "", nil, reason
+	}
+	return id, creds, ""
+}
+
+// admitUnix applies the uid policy to a Unix domain socket connection and
+// returns the peer's credentials when they are available.
+func (s *Server) admitUnix(conn *net.UnixConn) (*PeerCredentials, string) {
+	creds, err := peerCredentials(conn)
+	if err != nil {
+		s.logf("peer credentials unavailable: %v", err)
+		if len(s.options.PermittedUIDs) > 0 {
+			return nil, "peer credentials unavailable"
+		}
+		return nil, ""
+	}
+	if len(s.options.PermittedUIDs) > 0 && !slices.Contains(s.options.PermittedUIDs, creds.UID) {
+		return creds, fmt.Sprintf("uid %d not permitted", creds.UID)
+	}
+	return creds, ""
+}
+
+// accept runs Callbacks.OnAccept, completing the TLS handshake first so the
+// callback can inspect the peer's certificate.
+func (s *Server) accept(conn net.Conn) string {
+	if s.callbacks.OnAccept == nil {
+		return ""
+	}
+	var state *tls.ConnectionState
+	if tc, ok := conn.(*tls.Conn); ok {
+		tc.SetDeadline(time.Now().Add(handshakeTimeout))
+		err := tc.Handshake()
+		tc.SetDeadline(time.Time{})
+		if err != nil {
+			return "tls handshake: " + err.Error()
+		}
+		cs := tc.ConnectionState()
+		state = &cs
+	}
+	allow, reason := s.callbacks.OnAccept(conn.RemoteAddr(), state)
+	if allow {
+		return ""
+	}
+	if reason == "" {
+		reason = "rejected"
+	}
+	return reason
+}
+
+// refuse closes a connection that failed admission. With
+// Options.NotifyRejected the client is first sent StatusFailure carrying the
+// reason, and the connection is half-closed and drained briefly so the frame
+// is not lost to a reset.
+func (s *Server) refuse(conn net.Conn, reason string) {
+	addr := conn.RemoteAddr()
+	s.logf("rejected %s: %s", addr, reason)
+	if s.callbacks.OnRejected != nil {
+		s.callbacks.OnRejected(addr, reason)
+	}
+	defer conn.Close()
+	if !s.options.NotifyRejected {
+		return
+	}
+	hdr, err := message.BuildHeader(&message.Message{
+		Status:   message.StatusFailure,
+		Metadata: map[string]any{message.MetadataError: reason},
+	})
+	if err != nil {
+		return
+	}
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write(hdr); err != nil {
+		return
+	}
+	cw, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		cw, ok = rawConn(conn).(interface{ CloseWrite() error })
+	}
+	if ok {
+		cw.CloseWrite()
+	}
+	io.Copy(io.Discard, conn)
+}
+
+// rawConn returns the transport connection beneath TLS and the PROXY
+// protocol.
+func rawConn(conn net.Conn) net.Conn {
+	for {
+		u, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return conn
+		}
+		conn = u.NetConn()
+	}
+}
+
+// proxyConn returns the PROXY protocol layer of conn, if any.
+func proxyConn(conn net.Conn) (*proxyproto.Conn, bool) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	pc, ok := conn.(*proxyproto.Conn)
+	return pc, ok
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/internal/transport"
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// ipFilter holds the parsed permit and block lists.
+type ipFilter struct {
+	mu        sync.RWMutex
+	permitted []netip.Prefix
+	blocked   []netip.Prefix
+}
+
+// allowed reports whether ip passes the lists. Blocked entries win over
+// permitted ones, and an empty permit list allows everything not blocked.
+func (f *ipFilter) allowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, p := range f.blocked {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.permitted) == 0 {
+		return true
+	}
+	for _, p := range f.permitted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *ipFilter) restrictive() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.permitted) > 0
+}
+
+func (f *ipFilter) lists() (permitted, blocked []string) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return prefixStrings(f.permitted), prefixStrings(f.blocked)
+}
+
+func (f *ipFilter) replace(permitted, blocked []netip.Prefix) {
+	f.mu.Lock()
+	f.permitted, f.blocked = permitted, blocked
+	f.mu.Unlock()
+}
+
+func (f *ipFilter) update(fn func(permitted, blocked []netip.Prefix) ([]netip.Prefix, []netip.Prefix)) {
+	f.mu.Lock()
+	f.permitted, f.blocked = fn(f.permitted, f.blocked)
+	f.mu.Unlock()
+}
+
+func prefixStrings(list []netip.Prefix) []string {
+	out := make([]string, len(list))
+	for i, p := range list {
+		if p.IsSingleIP() {
+			out[i] = p.Addr().String()
+		} else {
+			out[i] = p.String()
+		}
+	}
+	return out
+}
+
+func addPrefixes(list, add []netip.Prefix) []netip.Prefix {
+	out := slices.Clone(list)
+	for _, p := range add {
+		if !slices.Contains(out, p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func removePrefixes(list, remove []netip.Prefix) []netip.Prefix {
+	return slices.DeleteFunc(slices.Clone(list), func(p netip.Prefix) bool {
+		return slices.Contains(remove, p)
+	})
+}
+
+// addrAllowed applies the IP lists to a client's remote address. Addresses
+// that are not IP addresses are only allowed while the permit list is empty.
+func (s *Server) addrAllowed(addr net.Addr) bool {
+	if addr == nil {
+		return !s.filter.restrictive()
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return !s.filter.restrictive()
+	}
+	return s.filter.allowed(ap.Addr())
+}
+
+// AddPermitted adds IP addresses or CIDR ranges to the permit list. Once the
+// list is not empty, only clients matching it may connect.
+func (s *Server) AddPermitted(entries ...string) error {
+	add, err := transport.ParsePrefixes(entries)
+	if err != nil {
+		return err
+	}
+	s.filter.update(func(permitted, blocked []netip.Prefix) ([]netip.Prefix, []netip.Prefix) {
+		return addPrefixes(permitted, add), blocked
+	})
+	s.enforceIPLists()
+	return nil
+}
+
+// AddBlocked adds IP addresses or CIDR ranges to the block list.
+func (s *Server) AddBlocked(entries ...string) error {
+	add, err := transport.ParsePrefixes(entries)
+	if err != nil {
+		return err
+	}
+	s.filter.update(func(permitted, blocked []netip.Prefix) ([]netip.Prefix, []netip.Prefix) {
+		return permitted, addPrefixes(blocked, add)
+	})
+	s.enforceIPLists()
+	return nil
+}
+
+// RemovePermitted removes entries from the permit list. Entries must match
+// those added exactly; removing an address does not split a range.
+func (s *Server) RemovePermitted(entries ...string) error {
+	remove, err := transport.ParsePrefixes(entries)
+	if err != nil {
+		return err
+	}
+	s.filter.update(func(permitted, blocked []netip.Prefix) ([]netip.Prefix, []netip.Prefix) {
+		return removePrefixes(permitted, remove), blocked
+	})
+	s.enforceIPLists()
+	return nil
+}
+
+// RemoveBlocked removes entries from the block list.
+func (s *Server) RemoveBlocked(entries ...string) error {
+	remove, err := transport.ParsePrefixes(entries)
+	if err != nil {
+		return err
+	}
+	s.filter.update(func(permitted, blocked []netip.Prefix) ([]netip.Prefix, []netip.Prefix) {
+		return permitted, removePrefixes(blocked, remove)
+	})
+	return nil
+}
+
+// ReplaceLists atomically replaces both lists. Neither list changes if an
+// entry is invalid.
+func (s *Server) ReplaceLists(permitted, blocked []string) error {
+	p, err := transport.ParsePrefixes(permitted)
+	if err != nil {
+		return err
+	}
+	b, err := transport.ParsePrefixes(blocked)
+	if err != nil {
+		return err
+	}
+	s.filter.replace(p, b)
+	s.enforceIPLists()
+	return nil
+}
+
+// PermittedIPs returns the current permit list.
+func (s *Server) PermittedIPs() []string {
+	p, _ := s.filter.lists()
+	return p
+}
+
+// BlockedIPs returns the current block list.
+func (s *Server) BlockedIPs() []string {
+	_, b := s.filter.lists()
+	return b
+}
+
+// enforceIPLists disconnects clients that the current lists no longer allow
+// when Options.DisconnectBlocked is set. Unix socket clients are governed by
+// the uid policy and are left alone.
+func (s *Server) enforceIPLists() {
+	if !s.options.DisconnectBlocked {
+		return
+	}
+	var drop []string
+	s.mu.Lock()
+	for id, c := range s.conns {
+		if _, ok := rawConn(c.conn).(*net.UnixConn); ok {
+			continue
+		}
+		if !s.addrAllowed(c.conn.RemoteAddr()) {
+			drop = append(drop, id)
+		}
+	}
+	s.mu.Unlock()
+	for _, id := range drop {
+		s.logf("disconnecting %s: no longer permitted", id)
+	}
+	s.removeAll(drop)
+}
+
+// removeAll removes the clients in ids concurrently, so that clients slow to
+// take the notification hold up the caller for one write timeout at most.
+func (s *Server) removeAll(ids []string) {
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.remove(id)
+		}()
+	}
+	wg.Wait()
+}
+
+// remove tells a client it has been removed and closes its connection.
+func (s *Server) remove(id string) {
+	c := s.client(id)
+	if c == nil {
+		return
+	}
+	s.closeClient(c, id, message.DisconnectRemoved, message.StatusRemoved)
+}
+
+// loadIPListFile reads permit and block lists from path. Each line is
+// "permit <entry>" or "block <entry>"; blank lines and lines starting with #
+// are ignored.
+func loadIPListFile(path string) (permitted, blocked []netip.Prefix, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var p, b []string
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("%s:%d: expected \"permit <ip>\" or \"block <ip>\"", path, n)
+		}
+		switch strings.ToLower(fields[0]) {
+		case "permit":
+			p = append(p, fields[1])
+		case "block":
+			b = append(b, fields[1])
+		default:
+			return nil, nil, fmt.Errorf("%s:%d: unknown list %q", path, n, fields[0])
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, err
+	}
+	if permitted, err = transport.ParsePrefixes(p); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if blocked, err = transport.ParsePrefixes(b); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return permitted, blocked, nil
+}
+
+// reloadIPListFile replaces the lists from Options.IPListFile.
+func (s *Server) reloadIPListFile() error {
+	p, b, err := loadIPListFile(s.options.IPListFile)
+	if err != nil {
+		return err
+	}
+	s.filter.replace(p, b)
+	s.enforceIPLists()
+	return nil
+}
+
+// watchIPListFile reloads Options.IPListFile whenever its modification time
+// or size changes. A file that fails to load leaves the lists unchanged.
+func (s *Server) watchIPListFile() {
+	interval := s.options.IPListReloadInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	stamp := func() (time.Time, int64) {
+		fi, err := os.Stat(s.options.IPListFile)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+	lastMod, lastSize := stamp()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			mod, size := stamp()
+			if size < 0 || (mod.Equal(lastMod) && size == lastSize) {
+				continue
+			}
+			lastMod, lastSize = mod, size
+			if err := s.reloadIPListFile(); err != nil {
+				s.logf("ip list reload failed: %v", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/WasimAhmad/watsontcp-go/internal/chunk"
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// fragment reports whether a payload should be split into interleavable
+// fragments. Sync responses are kept whole so that they are matched to
+// their request on arrival.
+func (s *Server) fragment(c *clientConn, msg *message.Message, p *message.Payload) bool {
+	if c.mux == nil || msg.SyncResponse {
+		return false
+	}
+	size := int64(s.options.ChunkSize)
+	if size <= 0 {
+		size = chunk.DefaultSize
+	}
+	if !p.IsStream() {
+		return int64(len(p.Data)) > size
+	}
+	return p.Length > size
+}
+
+// sendFragmented sends a payload of known length as a chunked stream on its
+// own logical channel.
+func (s *Server) sendFragmented(c *clientConn, id string, msg *message.Message, p *message.Payload) error {
+	r := p.Stream
+	if p.IsStream() {
+		r = chunk.Exact(p.Stream, p.Length)
+	} else {
+		p.Length = int64(len(p.Data))
+		r = bytes.NewReader(p.Data)
+	}
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]any)
+	}
+	msg.Metadata[message.MetadataStreamLength] = p.Length
+	s.logf("sending fragmented message to %s: %+v length=%d", id, msg, p.Length)
+	return s.sendChunks(context.Background(), c, id, msg, r)
+}
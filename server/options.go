@@ -1,12 +1,18 @@
 package server
 
-import "time"
+import (
+	"os"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
 
 // Options mirrors a subset of the configuration options available to the C#
 // WatsonTcp server implementation.
 type Options struct {
 	// IdleTimeout is the amount of time a connection can remain idle before it
-	// is terminated. Zero disables the check.
+	// is terminated. The client is sent StatusTimeout first. Zero disables
+	// the check; Server.SetIdleTimeout overrides it per client.
 	IdleTimeout time.Duration
 
 	// CheckInterval controls how often idle connections are evaluated.
@@ -28,9 +34,64 @@ type Options struct {
 	PermittedIPs []string
 
 	// BlockedIPs specifies IP addresses or CIDR ranges that should be
-	// rejected when a client attempts to connect.
+	// rejected when a client attempts to connect. Both lists can be changed
+	// while the server runs with AddPermitted, AddBlocked, RemovePermitted,
+	// RemoveBlocked and ReplaceLists.
 	BlockedIPs []string
 
+	// DisconnectBlocked closes connections of clients that are no longer
+	// permitted after the IP lists change at runtime, sending them
+	// StatusRemoved first.
+	DisconnectBlocked bool
+
+	// IPListFile names a file holding the permit and block lists, loaded on
+	// Start in place of PermittedIPs and BlockedIPs and reloaded whenever it
+	// changes. Each line is "permit <ip or cidr>" or "block <ip or cidr>";
+	// blank lines and lines starting with # are ignored. A file that fails
+	// to reload leaves the current lists in place.
+	IPListFile string
+
+	// IPListReloadInterval controls how often IPListFile is checked for
+	// changes. Zero uses five seconds.
+	IPListReloadInterval time.Duration
+
+	// Ban temporarily bans addresses that repeatedly fail the preshared key
+	// check or send malformed headers. Ban.MaxFailures must be set to enable
+	// it. Unix socket and other non-IP clients are never banned.
+	Ban BanPolicy
+
+	// NotifyRejected sends clients refused during admission a StatusFailure
+	// frame carrying the reason under message.MetadataError before closing,
+	// so that Connect on the client returns a *client.RejectedError. Leave
+	// it off where the reason should not be disclosed.
+	NotifyRejected bool
+
+	// UnixSocketMode sets the permissions of the socket file when Addr is a
+	// "unix://" path. Zero leaves the mode determined by the umask.
+	UnixSocketMode os.FileMode
+
+	// PermittedUIDs restricts Unix domain socket clients to processes
+	// running as one of these user ids. PermittedIPs and BlockedIPs do not
+	// apply to Unix socket connections. If empty, any process able to open
+	// the socket may connect. Where peer credentials are unavailable a
+	// non-empty list rejects every Unix socket client.
+	PermittedUIDs []uint32
+
+	// TrustedProxies lists the IP addresses or CIDR ranges of load
+	// balancers that prefix connections with a PROXY protocol v1 or v2
+	// header. When set, connections from these sources must send a header,
+	// and the client address it carries is used for the client id and for
+	// PermittedIPs and BlockedIPs. Headers from any other source are never
+	// honored; such connections fail on their first read before any message
+	// is delivered and are disconnected with DisconnectProtocolError.
+	// Admission never waits on other connections, since clients without a
+	// preshared key send nothing until they are registered.
+	TrustedProxies []string
+
+	// ProxyHeaderTimeout bounds how long to wait for a PROXY header from a
+	// trusted proxy. Zero uses five seconds.
+	ProxyHeaderTimeout time.Duration
+
 	// Logger is used when DebugMessages is true to output debug logs around
 	// send and receive operations. The function should behave like
 	// fmt.Printf.
@@ -39,8 +100,69 @@ type Options struct {
 	// DebugMessages enables logging of send and receive operations when a
 	// Logger is provided.
 	DebugMessages bool
+
+	// SendInterceptors run in order on every outbound message before it is
+	// written. An error aborts the send and is returned to the caller.
+	SendInterceptors []Interceptor
+
+	// ReceiveInterceptors run in order on every inbound message before it
+	// is delivered to callbacks. Returning message.ErrDropped discards the
+	// message; any other error replies to the client with StatusFailure and
+	// the error text in metadata.
+	ReceiveInterceptors []Interceptor
+
+	// ChunkSize is the maximum payload of each frame sent by SendChunked.
+	// Zero uses 64 KiB.
+	ChunkSize int
+
+	// Multiplex schedules outgoing frames through per-connection logical
+	// channels with round-robin fairness. Payloads larger than ChunkSize are
+	// fragmented into chunked streams on their own channel, so small
+	// messages are not held up behind bulk transfers. Fragmented payloads
+	// are only understood by peers running this library.
+	Multiplex bool
+
+	// Checksums adds a CRC32C of the payload to the header of every message
+	// sent. Received checksums are always verified; byte payloads that do
+	// not match are rejected and streams fail with
+	// message.ErrChecksumMismatch at EOF. Streams that do not implement
+	// io.Seeker are buffered in memory to compute the checksum, so prefer
+	// SendChunked for large unseekable streams; each chunk carries its own
+	// checksum. Relayed messages keep the sender's checksum, or go without
+	// one, since their payload is passed through unbuffered.
+	Checksums bool
+
+	// PubSub enables topic-based publish/subscribe. Subscribe, unsubscribe
+	// and publish control messages from clients are handled by the server
+	// and not delivered to OnMessage.
+	PubSub bool
+
+	// Relay enables forwarding of messages whose metadata names another
+	// client under message.MetadataDestination. Payloads are streamed
+	// through without buffering, sync responses are routed back to the
+	// original caller, and the origin is stamped under
+	// message.MetadataSender.
+	Relay bool
+
+	// RelayAuthorizer, if set, decides whether the client from may send msg
+	// to the client to. Refused messages are answered with StatusFailure.
+	RelayAuthorizer func(from, to string, msg *message.Message) bool
+
+	// RelayTimeout bounds how long the server routes the response to a
+	// relayed sync request that carries no ExpirationUtc. Requests that
+	// carry one are forgotten once it passes. Expiry is checked every
+	// CheckInterval. Zero uses 30 seconds.
+	RelayTimeout time.Duration
 }
 
+// defaultRelayTimeout applies when Options.RelayTimeout is zero.
+const defaultRelayTimeout = 30 * time.Second
+
+// Interceptor inspects or modifies a message and its payload as it passes
+// through the server. id identifies the client the message is exchanged
+// with. It applies uniformly to byte, stream and sync messages.
+type Interceptor func(id string, msg *message.Message, p *message.Payload) error
+
 // KeepAlive mirrors WatsonTcp keepalive settings.
 type KeepAlive struct {
 	Enable     bool
@@ -66,5 +188,6 @@ func DefaultOptions() Options {
 		BlockedIPs:     nil,
 		Logger:         nil,
 		DebugMessages:  false,
+		ChunkSize:      64 * 1024,
 	}
 }
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+	"github.com/WasimAhmad/watsontcp-go/pubsub"
+)
+
+// handlePubSub processes publish/subscribe control messages. It reports
+// whether msg was consumed.
+func (s *Server) handlePubSub(id string, msg *message.Message, data []byte) bool {
+	action, _ := msg.Metadata[message.MetadataPubSub].(string)
+	topic, _ := msg.Metadata[message.MetadataTopic].(string)
+	switch action {
+	case pubsub.ActionSubscribe:
+		if err := s.subscribe(id, topic); err != nil {
+			s.logf("subscribe %s to %q: %v", id, topic, err)
+		}
+	case pubsub.ActionUnsubscribe:
+		s.unsubscribe(id, topic)
+	case pubsub.ActionPublish:
+		if err := s.Publish(topic, data); err != nil {
+			s.logf("publish from %s to %q: %v", id, topic, err)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// publishQueueDepth bounds the publications waiting for one subscriber.
+// Further publications to a subscriber whose queue is full are dropped, so a
+// slow subscriber holds up neither the publisher nor other subscribers.
+const publishQueueDepth = 64
+
+// subscriber holds a client's patterns and the queue its publications are
+// delivered from, in order, off the publisher's read loop.
+type subscriber struct {
+	patterns map[string]struct{}
+	queue    chan publication
+}
+
+type publication struct {
+	topic string
+	data  []byte
+}
+
+func (s *Server) subscribe(id, pattern string) error {
+	if err := pubsub.ValidatePattern(pattern); err != nil {
+		return err
+	}
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	sub := s.subs[id]
+	if sub == nil {
+		// the queue lives until the client disconnects, so publications
+		// stay ordered across unsubscribing and subscribing again
+		sub = &subscriber{patterns: make(map[string]struct{}), queue: make(chan publication, publishQueueDepth)}
+		s.subs[id] = sub
+		go s.deliver(id, sub.queue)
+	}
+	sub.patterns[pattern] = struct{}{}
+	return nil
+}
+
+func (s *Server) unsubscribe(id, pattern string) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	if sub := s.subs[id]; sub != nil {
+		delete(sub.patterns, pattern)
+	}
+}
+
+func (s *Server) unsubscribeAll(id string) {
+	s.subsMu.Lock()
+	if sub := s.subs[id]; sub != nil {
+		close(sub.queue)
+		delete(s.subs, id)
+	}
+	s.subsMu.Unlock()
+}
+
+// deliver sends the publications queued for the client identified by id
+// until its queue is closed.
+func (s *Server) deliver(id string, queue <-chan publication) {
+	for p := range queue {
+		msg := &message.Message{
+			Status: message.StatusNormal,
+			Metadata: map[string]any{
+				message.MetadataPubSub: pubsub.ActionMessage,
+				message.MetadataTopic:  p.topic,
+			},
+		}
+		if err := s.Send(id, msg, p.data); err != nil {
+			s.logf("publish %q to %s: %v", p.topic, id, err)
+		}
+	}
+}
+
+// Subscriptions returns the patterns the client identified by id is
+// subscribed to.
+func (s *Server) Subscriptions(id string) []string {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	var out []string
+	if sub := s.subs[id]; sub != nil {
+		for p := range sub.patterns {
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Publish queues data for every client with a subscription matching topic
+// and returns without waiting for delivery. Each subscriber receives the
+// message once even if several of its patterns match. data must not be
+// modified afterwards. Publications to a subscriber that already has 64
+// waiting are dropped, and failed deliveries are logged; neither stops the
+// fan-out.
+func (s *Server) Publish(topic string, data []byte) error {
+	if err := pubsub.ValidateTopic(topic); err != nil {
+		return err
+	}
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for id, sub := range s.subs {
+		for p := range sub.patterns {
+			if pubsub.Match(p, topic) {
+				select {
+				case sub.queue <- publication{topic: topic, data: data}:
+				default:
+					s.logf("publish %q to %s: queue full, dropped", topic, id)
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
+
+// relayRoute records a relayed sync request awaiting its response until
+// expires.
+type relayRoute struct {
+	from    string
+	to      string
+	expires time.Time
+}
+
+// relayKey identifies a pending relayed sync request. Conversation ids are
+// chosen by clients, so they are only unique per destination.
+type relayKey struct {
+	to   string
+	guid string
+}
+
+// relayTarget returns the client msg should be relayed to, if any. Messages
+// naming a destination are relayed, as are sync responses from a client
+// answering a relayed sync request.
+func (s *Server) relayTarget(id string, msg *message.Message) (string, bool) {
+	if to, _ := msg.Metadata[message.MetadataDestination].(string); to != "" {
+		return to, true
+	}
+	if msg.SyncResponse && msg.ConversationGUID != "" {
+		if _, ok := s.respMap.Load(msg.ConversationGUID); ok {
+			return "", false
+		}
+		if val, ok := s.relayPending.Load(relayKey{to: id, guid: msg.ConversationGUID}); ok {
+			return val.(relayRoute).from, true
+		}
+	}
+	return "", false
+}
+
+// relay forwards msg from the client identified by from to the client to.
+// The payload is copied straight from the source connection so that large
+// streams are never buffered.
+func (s *Server) relay(c *clientConn, from, to string, msg *message.Message) {
+	lr := &io.LimitedReader{R: c.conn, N: msg.ContentLength}
+	defer func() {
+		if lr.N > 0 {
+			io.CopyN(io.Discard, c.conn, lr.N)
+		}
+	}()
+	s.stats.IncrementReceivedMessages()
+	s.stats.AddReceivedBytes(msg.ContentLength)
+	p := &message.Payload{Stream: lr, Length: msg.ContentLength, Relayed: true}
+	if err := s.intercept(s.options.ReceiveInterceptors, from, msg, p); err != nil {
+		s.reject(c, from, msg, err)
+		return
+	}
+	if msg.SyncResponse {
+		s.relayPending.Delete(relayKey{to: from, guid: msg.ConversationGUID})
+	} else if s.options.RelayAuthorizer != nil && !s.options.RelayAuthorizer(from, to, msg) {
+		s.reject(c, from, msg, fmt.Errorf("relay to %s not permitted", to))
+		return
+	}
+	if s.client(to) == nil {
+		s.reject(c, from, msg, fmt.Errorf("unknown destination %s", to))
+		return
+	}
+
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]any)
+	}
+	msg.Metadata[message.MetadataSender] = from
+	msg.SenderGUID = from
+	if msg.SyncRequest {
+		if msg.ConversationGUID == "" {
+			s.reject(c, from, msg, errors.New("relayed sync request without conversation id"))
+			return
+		}
+		key := relayKey{to: to, guid: msg.ConversationGUID}
+		rt := relayRoute{from: from, to: to, expires: s.relayExpiry(msg)}
+		if _, dup := s.relayPending.LoadOrStore(key, rt); dup {
+			s.reject(c, from, msg, fmt.Errorf("conversation %s to %s already pending", msg.ConversationGUID, to))
+			return
+		}
+	}
+	s.logf("relaying from %s to %s: %+v", from, to, msg)
+	if err := s.sendPayload(to, msg, p); err != nil {
+		if msg.SyncRequest {
+			s.relayPending.Delete(relayKey{to: to, guid: msg.ConversationGUID})
+		}
+		s.reject(c, from, msg, fmt.Errorf("relay to %s failed: %w", to, err))
+	}
+}
+
+// cancelRelays fails relayed sync requests that can no longer be answered
+// because id disconnected.
+func (s *Server) cancelRelays(id string) {
+	s.relayPending.Range(func(key, val any) bool {
+		rt := val.(relayRoute)
+		switch id {
+		case rt.from:
+			s.relayPending.Delete(key)
+		case rt.to:
+			s.relayPending.Delete(key)
+			if c := s.client(rt.from); c != nil {
+				req := &message.Message{SyncRequest: true, ConversationGUID: key.(relayKey).guid}
+				s.reject(c, rt.from, req, fmt.Errorf("destination %s disconnected", id))
+			}
+		}
+		return true
+	})
+}
+
+// relayExpiry returns when a relayed sync request stops waiting for its
+// response: its own expiration, or Options.RelayTimeout from now.
+func (s *Server) relayExpiry(msg *message.Message) time.Time {
+	if msg.ExpirationUtc != nil {
+		return *msg.ExpirationUtc
+	}
+	timeout := s.options.RelayTimeout
+	if timeout <= 0 {
+		timeout = defaultRelayTimeout
+	}
+	return time.Now().Add(timeout)
+}
+
+// expireRelays forgets relayed sync requests whose destination has not
+// answered in time. A response arriving later is not relayed.
+func (s *Server) expireRelays(now time.Time) {
+	s.relayPending.Range(func(key, val any) bool {
+		if rt := val.(relayRoute); now.After(rt.expires) && s.relayPending.CompareAndDelete(key, val) {
+			s.logf("relayed request %s from %s to %s expired", key.(relayKey).guid, rt.from, rt.to)
+		}
+		return true
+	})
+}
+
+// ListClients returns the ids of all connected clients.
+func (s *Server) ListClients() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.conns))
+	for id := range s.conns {
+		ids = append(ids, id)
+	}
+	return ids
+}
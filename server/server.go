@@ -1,22 +1,52 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/WasimAhmad/watsontcp-go/internal/chunk"
+	"github.com/WasimAhmad/watsontcp-go/internal/filexfer"
+	"github.com/WasimAhmad/watsontcp-go/internal/mux"
+	"github.com/WasimAhmad/watsontcp-go/internal/proxyproto"
+	"github.com/WasimAhmad/watsontcp-go/internal/transport"
 	"github.com/WasimAhmad/watsontcp-go/message"
 	"github.com/WasimAhmad/watsontcp-go/stats"
 )
 
 type Callbacks struct {
 	OnConnect    func(id string, conn net.Conn)
-	OnDisconnect func(id string)
+	OnDisconnect func(id string, reason message.DisconnectReason)
 	OnMessage    func(id string, msg *message.Message, data []byte)
 	OnStream     func(id string, msg *message.Message, r io.Reader)
+
+	// OnAccept, if set, decides whether a connection that passed the IP
+	// lists, bans and uid policy may register. tlsState is nil for
+	// connections without TLS; the handshake completes before OnAccept is
+	// called. A refused connection is closed, and reason is passed to
+	// OnRejected and, with Options.NotifyRejected, to the client.
+	OnAccept func(remoteAddr net.Addr, tlsState *tls.ConnectionState) (allow bool, reason string)
+
+	// OnRejected is called for every connection refused during admission,
+	// whatever the cause.
+	OnRejected func(remoteAddr net.Addr, reason string)
+
+	// OnError is called with read, parse and write failures on the
+	// connection of the client id. Errors that only mean the connection
+	// closed are not reported. Write failures are also returned to the
+	// sender where there is one.
+	OnError func(id string, err error)
+
+	// OnBan is called each time an address is banned, automatically or
+	// through Server.Ban.
+	OnBan func(ban BanEntry)
 }
 
 type Server struct {
@@ -30,16 +60,28 @@ type Server struct {
 
 	listener net.Listener
 	conns    map[string]*clientConn
+	respMap  sync.Map
 	mu       sync.Mutex
 
+	relayPending sync.Map
+
+	subs   map[string]*subscriber
+	subsMu sync.Mutex
+
+	files       *filexfer.Receiver
+	fileHandler FileHandler
+	filesMu     sync.Mutex
+
 	idleTimeout   time.Duration
 	checkInterval time.Duration
 
 	maxConnections int
-	permittedIPs   []string
-	blockedIPs     []string
+	filter         ipFilter
+	filterErr      error
+	bans           banTable
 
-	done chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
 func (s *Server) logf(format string, args ...any) {
@@ -48,10 +90,35 @@ func (s *Server) logf(format string, args ...any) {
 	}
 }
 
+type response struct {
+	msg  *message.Message
+	data []byte
+	err  error
+}
+
+// pendingSync is a sync request waiting for the response of client id.
+type pendingSync struct {
+	id string
+	ch chan *response
+}
+
 type clientConn struct {
-	conn       net.Conn
-	lastActive time.Time
-	mu         sync.Mutex
+	conn        net.Conn
+	lastActive  time.Time
+	connectedAt time.Time
+	creds       *PeerCredentials
+	mu          sync.Mutex
+	chunks      *chunk.Demux
+	mux         *mux.Writer
+
+	// idleTimeout overrides Server.idleTimeout when non-zero; negative
+	// disables the check. Guarded by Server.mu.
+	idleTimeout time.Duration
+
+	// closed and reason record why the server closed the connection;
+	// guarded by Server.mu
+	closed bool
+	reason message.DisconnectReason
 }
 
 // Statistics returns runtime counters for the server.
@@ -64,6 +131,8 @@ func New(addr string, tlsConf *tls.Config, cb Callbacks, opts *Options) *Server
 		defaultOpts := DefaultOptions()
 		opts = &defaultOpts
 	}
+	permitted, perr := transport.ParsePrefixes(opts.PermittedIPs)
+	blocked, berr := transport.ParsePrefixes(opts.BlockedIPs)
 	return &Server{
 		Addr:           addr,
 		TLSConfig:      tlsConf,
@@ -71,11 +140,12 @@ func New(addr string, tlsConf *tls.Config, cb Callbacks, opts *Options) *Server
 		options:        *opts,
 		stats:          stats.New(),
 		conns:          make(map[string]*clientConn),
+		subs:           make(map[string]*subscriber),
 		idleTimeout:    opts.IdleTimeout,
 		checkInterval:  opts.CheckInterval,
 		maxConnections: opts.MaxConnections,
-		permittedIPs:   opts.PermittedIPs,
-		blockedIPs:     opts.BlockedIPs,
+		filter:         ipFilter{permitted: permitted, blocked: blocked},
+		filterErr:      errors.Join(perr, berr),
 		done:           make(chan struct{}),
 	}
 }
@@ -84,10 +154,41 @@ func (s *Server) Start() error {
 	if s.listener != nil {
 		return errors.New("server already started")
 	}
-	ln, err := net.Listen("tcp", s.Addr)
+	ln, err := transport.Listen(s.Addr, s.options.UnixSocketMode)
 	if err != nil {
 		return err
 	}
+	return s.Serve(ln)
+}
+
+// Serve starts accepting connections on ln instead of listening on Addr,
+// for pre-bound or socket-activated listeners, in-memory transports and
+// custom tunnels. Like Start it returns once the server is running, and ln
+// is closed by Stop. Connections are wrapped in TLS when TLSConfig is set.
+//
+// Clients whose remote address is not an IP address are given generated
+// ids. They are not matched against BlockedIPs and are rejected when
+// PermittedIPs is not empty.
+func (s *Server) Serve(ln net.Listener) error {
+	if s.listener != nil {
+		return errors.New("server already started")
+	}
+	if s.filterErr != nil {
+		return s.filterErr
+	}
+	if s.options.IPListFile != "" {
+		if err := s.reloadIPListFile(); err != nil {
+			return err
+		}
+		go s.watchIPListFile()
+	}
+	if len(s.options.TrustedProxies) > 0 {
+		trusted, err := transport.ParsePrefixes(s.options.TrustedProxies)
+		if err != nil {
+			return err
+		}
+		ln = proxyproto.NewListener(ln, trusted, s.options.ProxyHeaderTimeout)
+	}
 	if s.TLSConfig != nil {
 		ln = tls.NewListener(ln, s.TLSConfig)
 	}
@@ -97,17 +198,32 @@ func (s *Server) Start() error {
 	return nil
 }
 
+// Stop closes the listener and sends StatusShutdown to every client before
+// disconnecting it. Calling Stop more than once has no effect.
 func (s *Server) Stop() {
-	close(s.done)
-	if s.listener != nil {
-		s.listener.Close()
-	}
-	s.mu.Lock()
-	for id, c := range s.conns {
-		c.conn.Close()
-		delete(s.conns, id)
-	}
-	s.mu.Unlock()
+	s.stopOnce.Do(func() {
+		close(s.done)
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		s.mu.Lock()
+		conns := s.conns
+		s.conns = make(map[string]*clientConn)
+		for _, c := range conns {
+			c.closed, c.reason = true, message.DisconnectShutdown
+		}
+		s.mu.Unlock()
+		var wg sync.WaitGroup
+		for id, c := range conns {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.notify(c, id, message.StatusShutdown)
+				c.conn.Close()
+			}()
+		}
+		wg.Wait()
+	})
 }
 
 func (s *Server) acceptLoop() {
@@ -121,36 +237,52 @@ func (s *Server) acceptLoop() {
 			}
 			continue
 		}
-		remoteHost, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-		if !s.ipAllowed(remoteHost) {
-			conn.Close()
-			continue
-		}
-		s.mu.Lock()
-		if s.maxConnections > 0 && len(s.conns) >= s.maxConnections {
-			s.mu.Unlock()
-			if tcp, ok := conn.(*net.TCPConn); ok {
-				tcp.SetLinger(0)
-			}
-			conn.Close()
-			continue
-		}
-		if s.options.KeepAlive.Enable {
-			if tcp, ok := conn.(*net.TCPConn); ok {
-				tcp.SetKeepAlive(true)
-				if s.options.KeepAlive.Interval > 0 {
-					tcp.SetKeepAlivePeriod(s.options.KeepAlive.Interval)
-				}
-			}
-		}
-		id := conn.RemoteAddr().String()
-		s.conns[id] = &clientConn{conn: conn, lastActive: time.Now()}
+		// admission may block reading a PROXY header
+		go s.register(conn)
+	}
+}
+
+// register admits conn and starts serving it.
+func (s *Server) register(conn net.Conn) {
+	id, creds, reason := s.admit(conn)
+	if reason != "" {
+		s.refuse(conn, reason)
+		return
+	}
+	tcp, _ := rawConn(conn).(*net.TCPConn)
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		conn.Close()
+		return
+	default:
+	}
+	if s.maxConnections > 0 && len(s.conns) >= s.maxConnections {
 		s.mu.Unlock()
-		if s.callbacks.OnConnect != nil {
-			go s.callbacks.OnConnect(id, conn)
+		if tcp != nil && !s.options.NotifyRejected {
+			tcp.SetLinger(0)
 		}
-		go s.handleConn(id)
+		s.refuse(conn, "too many connections")
+		return
+	}
+	if s.options.KeepAlive.Enable && tcp != nil {
+		tcp.SetKeepAlive(true)
+		if s.options.KeepAlive.Interval > 0 {
+			tcp.SetKeepAlivePeriod(s.options.KeepAlive.Interval)
+		}
+	}
+	now := time.Now()
+	cc := &clientConn{conn: conn, lastActive: now, connectedAt: now, creds: creds, chunks: chunk.NewDemux(s.chunkCanceler(id))}
+	if s.options.Multiplex {
+		cc.mux = mux.New(conn)
 	}
+	s.conns[id] = cc
+	s.mu.Unlock()
+	if s.callbacks.OnConnect != nil {
+		go s.callbacks.OnConnect(id, conn)
+	}
+	go s.handleConn(id)
 }
 
 func (s *Server) handleConn(id string) {
@@ -162,25 +294,47 @@ func (s *Server) handleConn(id string) {
 	if c == nil {
 		return
 	}
+	reason := message.DisconnectNormal
 	defer func() {
 		c.conn.Close()
+		c.chunks.Close(errors.New("connection closed"))
+		if c.mux != nil {
+			c.mux.Close(errors.New("connection closed"))
+		}
 		s.mu.Lock()
+		if c.closed {
+			reason = c.reason
+		}
 		delete(s.conns, id)
 		s.mu.Unlock()
+		// failures are counted against the client's address once it has
+		// been dropped, so a resulting ban does not try to remove it again
+		if reason == message.DisconnectAuthFailure || reason == message.DisconnectProtocolError {
+			s.recordFailure(c.conn, reason.String())
+		}
+		s.unsubscribeAll(id)
+		s.cancelRelays(id)
+		s.failPending(id)
+		s.stats.IncrementDisconnects(reason)
 		if s.callbacks.OnDisconnect != nil {
-			s.callbacks.OnDisconnect(id)
+			s.callbacks.OnDisconnect(id, reason)
 		}
 	}()
 	if s.options.PresharedKey != "" {
 		msg, err := message.ParseHeader(c.conn)
 		if err != nil {
+			s.report(id, err)
+			reason = disconnectReason(err)
 			return
 		}
 		payload := make([]byte, msg.ContentLength)
 		if _, err := io.ReadFull(c.conn, payload); err != nil {
+			s.report(id, err)
+			reason = disconnectReason(err)
 			return
 		}
 		if msg.Status != message.StatusAuthRequested || string(msg.PresharedKey) != s.options.PresharedKey {
+			reason = message.DisconnectAuthFailure
 			resp := &message.Message{Status: message.StatusAuthFailure}
 			if hdr, err := message.BuildHeader(resp); err == nil {
 				c.conn.Write(hdr)
@@ -202,26 +356,51 @@ func (s *Server) handleConn(id string) {
 	for {
 		msg, err := message.ParseHeader(c.conn)
 		if err != nil {
-			if err != io.EOF && s.callbacks.OnDisconnect != nil {
-				// connection error
-			}
+			s.report(id, err)
+			reason = disconnectReason(err)
 			return
 		}
 		s.logf("received from %s: %+v", id, msg)
-		if s.callbacks.OnStream != nil && s.callbacks.OnMessage == nil {
+		if msg.Status == message.StatusTimeout {
+			// the client is closing an idle connection
+			reason = message.DisconnectIdleTimeout
+			return
+		}
+		if s.options.Relay {
+			if to, ok := s.relayTarget(id, msg); ok {
+				s.relay(c, id, to, msg)
+				continue
+			}
+		}
+		if chunk.IsFrame(msg) {
+			if !s.readChunk(c, id, msg) {
+				reason = message.DisconnectIOError
+				return
+			}
+			continue
+		}
+		controlMsg := s.options.PubSub && msg.Metadata[message.MetadataPubSub] != nil || filexfer.IsTransfer(msg)
+		if s.callbacks.OnStream != nil && s.callbacks.OnMessage == nil && !msg.SyncResponse && !controlMsg {
 			lr := &io.LimitedReader{R: c.conn, N: msg.ContentLength}
 			s.stats.IncrementReceivedMessages()
 			s.stats.AddReceivedBytes(msg.ContentLength)
 			s.mu.Lock()
 			c.lastActive = time.Now()
 			s.mu.Unlock()
-			s.callbacks.OnStream(id, msg, lr)
+			p := &message.Payload{Stream: s.verifyStream(msg, lr), Length: msg.ContentLength}
+			if err := s.intercept(s.options.ReceiveInterceptors, id, msg, p); err != nil {
+				s.reject(c, id, msg, err)
+			} else {
+				s.callbacks.OnStream(id, msg, p.Stream)
+			}
 			if lr.N > 0 {
 				io.CopyN(io.Discard, c.conn, lr.N)
 			}
 		} else {
 			payload := make([]byte, msg.ContentLength)
 			if _, err := io.ReadFull(c.conn, payload); err != nil {
+				s.report(id, err)
+				reason = disconnectReason(err)
 				return
 			}
 			s.logf("received %d bytes from %s", len(payload), id)
@@ -230,46 +409,237 @@ func (s *Server) handleConn(id string) {
 			s.mu.Lock()
 			c.lastActive = time.Now()
 			s.mu.Unlock()
+			p := &message.Payload{Data: payload, Length: int64(len(payload))}
+			ierr := s.verify(id, msg, p.Data)
+			if ierr == nil {
+				ierr = s.intercept(s.options.ReceiveInterceptors, id, msg, p)
+			}
+			if msg.SyncResponse && msg.ConversationGUID != "" {
+				if val, ok := s.respMap.LoadAndDelete(msg.ConversationGUID); ok {
+					ch := val.(pendingSync).ch
+					ch <- &response{msg: msg, data: p.Data, err: ierr}
+					close(ch)
+					continue
+				}
+			}
+			if ierr != nil {
+				s.reject(c, id, msg, ierr)
+				continue
+			}
+			if s.options.PubSub && s.handlePubSub(id, msg, p.Data) {
+				continue
+			}
+			if filexfer.IsTransfer(msg) {
+				go s.handleFile(id, msg, p.Data)
+				continue
+			}
 			if s.callbacks.OnMessage != nil {
-				s.callbacks.OnMessage(id, msg, payload)
+				s.callbacks.OnMessage(id, msg, p.Data)
 			}
 		}
 	}
 }
 
+// Send transmits msg with data to the client identified by id.
+func (s *Server) Send(id string, msg *message.Message, data []byte) error {
+	return s.sendPayload(id, msg, &message.Payload{Data: data})
+}
+
 func (s *Server) SendStream(id string, msg *message.Message, r io.Reader, length int64) error {
 	if r == nil {
 		return errors.New("reader nil")
 	}
-	s.mu.Lock()
-	c := s.conns[id]
-	s.mu.Unlock()
+	return s.sendPayload(id, msg, &message.Payload{Stream: r, Length: length})
+}
+
+// sendPayload runs the send interceptors on p and writes it to the client
+// identified by id.
+func (s *Server) sendPayload(id string, msg *message.Message, p *message.Payload) error {
+	c := s.client(id)
 	if c == nil {
-		return errors.New("unknown client")
+		return ErrUnknownClient
+	}
+	if err := s.intercept(s.options.SendInterceptors, id, msg, p); err != nil {
+		return err
+	}
+	if s.fragment(c, msg, p) {
+		return s.sendFragmented(c, id, msg, p)
+	}
+	return s.write(c, id, msg, p)
+}
+
+// SendSync sends msg with data to the client identified by id and waits for
+// the matching sync response or for ctx to be done. It fails with
+// ErrNotConnected if the client disconnects first.
+func (s *Server) SendSync(ctx context.Context, id string, msg *message.Message, data []byte) (*message.Message, []byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	guid := msg.ConversationGUID
+	if guid == "" {
+		guid = newGUID()
+		msg.ConversationGUID = guid
+	}
+	msg.SyncRequest = true
+	ch := make(chan *response, 1)
+	s.respMap.Store(guid, pendingSync{id: id, ch: ch})
+	if err := s.Send(id, msg, data); err != nil {
+		s.respMap.Delete(guid)
+		return nil, nil, err
+	}
+	select {
+	case resp := <-ch:
+		return resp.msg, resp.data, resp.err
+	case <-ctx.Done():
+		s.respMap.Delete(guid)
+		return nil, nil, ctx.Err()
+	}
+}
+
+// failPending fails the sync requests still waiting for a response from the
+// client identified by id, which has disconnected.
+func (s *Server) failPending(id string) {
+	s.respMap.Range(func(key, val any) bool {
+		if p := val.(pendingSync); p.id == id && s.respMap.CompareAndDelete(key, val) {
+			p.ch <- &response{err: ErrNotConnected}
+			close(p.ch)
+		}
+		return true
+	})
+}
+
+func (s *Server) client(id string) *clientConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns[id]
+}
+
+// write frames msg with its payload and writes it to c without running
+// interceptors.
+func (s *Server) write(c *clientConn, id string, msg *message.Message, p *message.Payload) error {
+	return s.writeOn(c, id, "", msg, p)
+}
+
+// writeOn is like write but queues the frame on a logical channel when
+// multiplexing is enabled.
+func (s *Server) writeOn(c *clientConn, id, channel string, msg *message.Message, p *message.Payload) error {
+	if !p.IsStream() {
+		p.Length = int64(len(p.Data))
+	}
+	s.logf("sending to %s: %+v length=%d", id, msg, p.Length)
+	// a reused message may still carry the checksum of an earlier payload.
+	// Relayed streams pass the sender's bytes and checksum through as is
+	// rather than buffering them to checksum again.
+	if !p.Relayed {
+		msg.Checksum = ""
+	}
+	if s.options.Checksums && !p.Relayed {
+		sum, err := message.ChecksumPayload(p)
+		if err != nil {
+			return err
+		}
+		msg.Checksum = sum
 	}
-	s.logf("sending to %s: %+v length=%d", id, msg, length)
-	msg.ContentLength = length
+	msg.ContentLength = p.Length
 	msg.TimestampUtc = time.Now().UTC()
 	header, err := message.BuildHeader(msg)
 	if err != nil {
 		return err
 	}
+	if c.mux != nil {
+		data := p.Data
+		if p.IsStream() {
+			data = make([]byte, p.Length)
+			if _, err := io.ReadFull(p.Stream, data); err != nil {
+				return err
+			}
+		}
+		if err := c.mux.Write(channel, header, data); err != nil {
+			s.report(id, err)
+			return err
+		}
+	} else if err := c.writeDirect(header, p); err != nil {
+		s.report(id, err)
+		return err
+	}
+	s.stats.IncrementSentMessages()
+	s.stats.AddSentBytes(int64(len(header)) + p.Length)
+	s.logf("sent %d bytes to %s", int64(len(header))+p.Length, id)
+	return nil
+}
+
+func (c *clientConn) writeDirect(header []byte, p *message.Payload) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if _, err := c.conn.Write(header); err != nil {
 		return err
 	}
-	if length > 0 {
-		if _, err := io.CopyN(c.conn, r, length); err != nil {
+	if p.IsStream() {
+		if p.Length > 0 {
+			if _, err := io.CopyN(c.conn, p.Stream, p.Length); err != nil {
+				return err
+			}
+		}
+	} else if len(p.Data) > 0 {
+		if _, err := c.conn.Write(p.Data); err != nil {
 			return err
 		}
 	}
-	s.stats.IncrementSentMessages()
-	s.stats.AddSentBytes(int64(len(header)) + length)
-	s.logf("sent %d bytes to %s", int64(len(header))+length, id)
 	return nil
 }
 
+// verify checks a payload received from id against the checksum in its
+// header.
+func (s *Server) verify(id string, msg *message.Message, data []byte) error {
+	if !message.VerifyChecksum(msg, data) {
+		s.stats.IncrementChecksumMismatches()
+		s.logf("checksum mismatch from %s: %+v", id, msg)
+		return message.ErrChecksumMismatch
+	}
+	msg.Checksum = ""
+	return nil
+}
+
+// verifyStream wraps a received stream so that it is checked against the
+// checksum in its header when fully read.
+func (s *Server) verifyStream(msg *message.Message, r io.Reader) io.Reader {
+	if msg.Checksum == "" {
+		return r
+	}
+	want := msg.Checksum
+	msg.Checksum = ""
+	return message.VerifyReader(r, want, s.stats.IncrementChecksumMismatches)
+}
+
+// intercept runs chain in order, stopping at the first error.
+func (s *Server) intercept(chain []Interceptor, id string, msg *message.Message, p *message.Payload) error {
+	for _, fn := range chain {
+		if err := fn(id, msg, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reject answers a message refused by a receive interceptor with a
+// StatusFailure frame. Dropped messages, failures and sync responses are
+// never answered so that two peers cannot bounce rejections back and forth.
+func (s *Server) reject(c *clientConn, id string, msg *message.Message, err error) {
+	if errors.Is(err, message.ErrDropped) || msg.Status == message.StatusFailure || msg.SyncResponse {
+		s.logf("dropped message from %s: %v", id, err)
+		return
+	}
+	resp := &message.Message{
+		Status:           message.StatusFailure,
+		Metadata:         map[string]any{message.MetadataError: err.Error()},
+		SyncResponse:     msg.SyncRequest,
+		ConversationGUID: msg.ConversationGUID,
+	}
+	if werr := s.write(c, id, resp, &message.Payload{}); werr != nil {
+		s.logf("reject reply to %s failed: %v", id, werr)
+	}
+}
+
 func (s *Server) monitorLoop() {
 	ticker := time.NewTicker(s.checkInterval)
 	defer ticker.Stop()
@@ -280,17 +650,20 @@ func (s *Server) monitorLoop() {
 			var toClose []string
 			s.mu.Lock()
 			for id, c := range s.conns {
-				if now.Sub(c.lastActive) > s.idleTimeout {
+				timeout := c.idleTimeout
+				if timeout == 0 {
+					timeout = s.idleTimeout
+				}
+				if timeout > 0 && now.Sub(c.lastActive) > timeout {
 					toClose = append(toClose, id)
 				}
 			}
 			s.mu.Unlock()
+			s.bans.prune(now, s.options.Ban)
+			s.expireRelays(now)
 			for _, id := range toClose {
-				s.mu.Lock()
-				c := s.conns[id]
-				s.mu.Unlock()
-				if c != nil {
-					c.conn.Close()
+				if c := s.client(id); c != nil {
+					s.closeClient(c, id, message.DisconnectIdleTimeout, message.StatusTimeout)
 				}
 			}
 		case <-s.done:
@@ -299,33 +672,44 @@ func (s *Server) monitorLoop() {
 	}
 }
 
-func (s *Server) ipAllowed(addr string) bool {
-	ip := net.ParseIP(addr)
-	if ip == nil {
-		return false
+// SetIdleTimeout overrides Options.IdleTimeout for the client identified by
+// id, for example from OnConnect once ClientInfo has identified the peer. A
+// negative d never times the client out and zero restores the default.
+func (s *Server) SetIdleTimeout(id string, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.conns[id]
+	if c == nil {
+		return ErrUnknownClient
 	}
-	for _, b := range s.blockedIPs {
-		if ipMatch(ip, b) {
-			return false
-		}
+	c.idleTimeout = d
+	return nil
+}
+
+// closeClient closes the connection of client id, recording reason for
+// OnDisconnect. A non-empty status is sent to the client first.
+func (s *Server) closeClient(c *clientConn, id string, reason message.DisconnectReason, status message.MessageStatus) {
+	s.mu.Lock()
+	if !c.closed {
+		c.closed, c.reason = true, reason
 	}
-	if len(s.permittedIPs) > 0 {
-		for _, p := range s.permittedIPs {
-			if ipMatch(ip, p) {
-				return true
-			}
-		}
-		return false
+	s.mu.Unlock()
+	if status != "" {
+		s.notify(c, id, status)
 	}
-	return true
+	c.conn.Close()
 }
 
-func ipMatch(ip net.IP, pattern string) bool {
-	if ip2 := net.ParseIP(pattern); ip2 != nil {
-		return ip.Equal(ip2)
-	}
-	if _, netw, err := net.ParseCIDR(pattern); err == nil && netw != nil {
-		return netw.Contains(ip)
+// notify sends a bare status frame, giving up after a second.
+func (s *Server) notify(c *clientConn, id string, status message.MessageStatus) {
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	s.write(c, id, &message.Message{Status: status}, &message.Payload{})
+}
+
+func newGUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
 	}
-	return false
+	return hex.EncodeToString(b)
 }
@@ -2,8 +2,11 @@ package stats
 
 import (
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
 )
 
 // Statistics tracks counts of bytes and messages sent and received.
@@ -13,6 +16,10 @@ type Statistics struct {
 	receivedMsgs  int64
 	sentBytes     int64
 	sentMsgs      int64
+	checksumFails int64
+
+	disconnectsMu sync.Mutex
+	disconnects   map[message.DisconnectReason]int64
 }
 
 // New creates a new Statistics value with the start time set to now.
@@ -68,12 +75,71 @@ func (s *Statistics) AddSentBytes(n int64) { atomic.AddInt64(&s.sentBytes, n) }
 // IncrementSentMessages increments the sent message counter.
 func (s *Statistics) IncrementSentMessages() { atomic.AddInt64(&s.sentMsgs, 1) }
 
+// ChecksumMismatches returns the number of received payloads that did not
+// match the checksum in their header.
+func (s *Statistics) ChecksumMismatches() int64 { return atomic.LoadInt64(&s.checksumFails) }
+
+// IncrementChecksumMismatches increments the checksum mismatch counter.
+func (s *Statistics) IncrementChecksumMismatches() { atomic.AddInt64(&s.checksumFails, 1) }
+
+// Disconnects returns the number of connections that ended for reason.
+func (s *Statistics) Disconnects(reason message.DisconnectReason) int64 {
+	s.disconnectsMu.Lock()
+	defer s.disconnectsMu.Unlock()
+	return s.disconnects[reason]
+}
+
+// IncrementDisconnects increments the disconnect counter for reason.
+func (s *Statistics) IncrementDisconnects(reason message.DisconnectReason) {
+	s.disconnectsMu.Lock()
+	defer s.disconnectsMu.Unlock()
+	if s.disconnects == nil {
+		s.disconnects = make(map[message.DisconnectReason]int64)
+	}
+	s.disconnects[reason]++
+}
+
+// Merge adds the counters of other to s, for totals across several
+// connections. The start time of s is kept.
+func (s *Statistics) Merge(other *Statistics) {
+	atomic.AddInt64(&s.receivedBytes, other.ReceivedBytes())
+	atomic.AddInt64(&s.receivedMsgs, other.ReceivedMessages())
+	atomic.AddInt64(&s.sentBytes, other.SentBytes())
+	atomic.AddInt64(&s.sentMsgs, other.SentMessages())
+	atomic.AddInt64(&s.checksumFails, other.ChecksumMismatches())
+	other.disconnectsMu.Lock()
+	counts := make(map[message.DisconnectReason]int64, len(other.disconnects))
+	for r, n := range other.disconnects {
+		counts[r] = n
+	}
+	other.disconnectsMu.Unlock()
+	s.disconnectsMu.Lock()
+	defer s.disconnectsMu.Unlock()
+	if s.disconnects == nil {
+		s.disconnects = make(map[message.DisconnectReason]int64)
+	}
+	for r, n := range counts {
+		s.disconnects[r] += n
+	}
+}
+
+// Clone returns a copy of s with the same start time.
+func (s *Statistics) Clone() *Statistics {
+	c := &Statistics{startTime: s.startTime}
+	c.Merge(s)
+	return c
+}
+
 // Reset sets counters back to zero preserving the start time.
 func (s *Statistics) Reset() {
 	atomic.StoreInt64(&s.receivedBytes, 0)
 	atomic.StoreInt64(&s.receivedMsgs, 0)
 	atomic.StoreInt64(&s.sentBytes, 0)
 	atomic.StoreInt64(&s.sentMsgs, 0)
+	atomic.StoreInt64(&s.checksumFails, 0)
+	s.disconnectsMu.Lock()
+	s.disconnects = nil
+	s.disconnectsMu.Unlock()
 }
 
 // String returns a formatted human-readable representation of the statistics.
@@ -1,6 +1,10 @@
 package stats
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/WasimAhmad/watsontcp-go/message"
+)
 
 func TestMessageSizeAverages(t *testing.T) {
 	s := New()
@@ -20,9 +24,19 @@ func TestMessageSizeAverages(t *testing.T) {
 		t.Fatalf("expected avg 50 got %d", s.SentMessageSizeAverage())
 	}
 
+	s.IncrementChecksumMismatches()
+	if s.ChecksumMismatches() != 1 {
+		t.Fatalf("expected 1 checksum mismatch got %d", s.ChecksumMismatches())
+	}
+
+	s.IncrementDisconnects(message.DisconnectIdleTimeout)
+	if s.Disconnects(message.DisconnectIdleTimeout) != 1 || s.Disconnects(message.DisconnectNormal) != 0 {
+		t.Fatalf("unexpected disconnect counts")
+	}
+
 	start := s.StartTime()
 	s.Reset()
-	if s.ReceivedBytes() != 0 || s.SentBytes() != 0 || s.ReceivedMessages() != 0 || s.SentMessages() != 0 {
+	if s.ReceivedBytes() != 0 || s.SentBytes() != 0 || s.ReceivedMessages() != 0 || s.SentMessages() != 0 || s.ChecksumMismatches() != 0 || s.Disconnects(message.DisconnectIdleTimeout) != 0 {
 		t.Fatalf("reset did not clear counters")
 	}
 	if !s.StartTime().Equal(start) {
@@ -37,3 +51,21 @@ func TestStringOutput(t *testing.T) {
 		t.Fatalf("unexpected string output: %q", out)
 	}
 }
+
+func TestMerge(t *testing.T) {
+	a, b := New(), New()
+	a.AddSentBytes(10)
+	a.IncrementSentMessages()
+	b.AddSentBytes(30)
+	b.IncrementSentMessages()
+	b.IncrementDisconnects(message.DisconnectIOError)
+
+	total := a.Clone()
+	total.Merge(b)
+	if total.SentBytes() != 40 || total.SentMessages() != 2 || total.Disconnects(message.DisconnectIOError) != 1 {
+		t.Fatalf("unexpected totals: %d bytes, %d messages", total.SentBytes(), total.SentMessages())
+	}
+	if !total.StartTime().Equal(a.StartTime()) || a.SentBytes() != 10 {
+		t.Fatalf("clone should keep the start time and leave the original alone")
+	}
+}
@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T) (*Listener, string) {
+	ln := NewListener()
+	hs := httptest.NewServer(ln)
+	t.Cleanup(func() {
+		ln.Close()
+		hs.Close()
+	})
+	return ln, "ws://" + hs.Listener.Addr().String() + "/watson"
+}
+
+func dialPair(t *testing.T) (client, server net.Conn) {
+	ln, url := serve(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	cli, err := Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	select {
+	case srv := <-accepted:
+		t.Cleanup(func() { srv.Close() })
+		return cli, srv
+	case <-time.After(2 * time.Second):
+		t.Fatalf("connection not accepted")
+	}
+	return nil, nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	cli, srv := dialPair(t)
+	for _, size := range []int{0, 5, 125, 126, 70000} {
+		want := bytes.Repeat([]byte{'x'}, size)
+		want = append(want, 'y')
+		go cli.Write(want)
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(srv, got); err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("client to server size %d: %v", size, err)
+		}
+		go srv.Write(want)
+		if _, err := io.ReadFull(cli, got); err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("server to client size %d: %v", size, err)
+		}
+	}
+
+	cli.Close()
+	if _, err := srv.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF after close, got %v", err)
+	}
+}
+
+func TestPingAndMasking(t *testing.T) {
+	ln, url := serve(t)
+	raw, err := net.Dial("tcp", strings.TrimPrefix(strings.TrimSuffix(url, "/watson"), "ws://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	io.WriteString(raw, "GET /watson HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(raw)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: %v %v", resp, err)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %q", got)
+	}
+	srv, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	go srv.Read(make([]byte, 1))
+
+	// masked ping with payload "hi"
+	raw.Write([]byte{0x89, 0x82, 1, 2, 3, 4, 'h' ^ 1, 'i' ^ 2})
+	pong := make([]byte, 4)
+	if _, err := io.ReadFull(br, pong); err != nil || !bytes.Equal(pong, []byte{0x8a, 2, 'h', 'i'}) {
+		t.Fatalf("unexpected pong % x: %v", pong, err)
+	}
+
+	// unmasked data frames from a client are a protocol violation
+	raw.Write([]byte{0x82, 1, 'x'})
+	closeFrame := make([]byte, 4)
+	if _, err := io.ReadFull(br, closeFrame); err != nil || closeFrame[0] != 0x88 || closeFrame[3] != 0xea {
+		t.Fatalf("expected close 1002, got % x: %v", closeFrame, err)
+	}
+}
+
+func TestOriginCheck(t *testing.T) {
+	_, url := serve(t)
+	req, _ := http.NewRequest(http.MethodGet, strings.Replace(url, "ws://", "http://", 1), nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Origin", "https://evil.example")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("cross-origin request got %s", resp.Status)
+	}
+
+	if _, err := Dial(context.Background(), "http://example.com", nil); err == nil {
+		t.Fatalf("accepted a non-websocket scheme")
+	}
+}